@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
 	"batchdag/internal/api"
@@ -14,49 +17,177 @@ func main() {
 	registry := core.NewWorkerRegistry()
 	jobManager := core.NewJobManager()
 	queue := scheduler.NewTaskQueue()
+
+	// pools del fair scheduler, p.ej. {"interactive": {"weight": 2, "min_share": 4}}
+	if v := os.Getenv("SCHEDULER_POOLS"); v != "" {
+		pools, err := scheduler.ParsePools([]byte(v))
+		if err != nil {
+			log.Fatal("invalid SCHEDULER_POOLS: ", err)
+		}
+		queue.SetPools(pools)
+	}
 	sched := scheduler.NewScheduler(registry, jobManager, queue)
 
+	// ejecución especulativa: SPECULATION=true, y opcionalmente
+	// SPECULATION_QUANTILE (0.75) y SPECULATION_MULTIPLIER (1.5)
+	if err := speculationFromEnv(&sched.Speculation); err != nil {
+		log.Fatal(err)
+	}
+	// cuánto dura la exclusión de un worker que falla seguido (5m)
+	if v := os.Getenv("BLACKLIST_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid BLACKLIST_TIMEOUT %q", v)
+		}
+		sched.Blacklist.Timeout = d
+	}
+	// timeout de los intentos de los stages que no declaran uno (30m)
+	if v := os.Getenv("TASK_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid TASK_TIMEOUT %q", v)
+		}
+		sched.TaskTimeout = d
+	}
+
 	// conectar jobManager -> scheduler (sin importar imports)
 	jobManager.EnqueueFn = sched.EnqueueAssignment
+	jobManager.CancelFn = sched.CancelJob
+	jobManager.FinishedFn = sched.JobFinished
 
-	// start scheduler
-	sched.Start()
+	stateDir := os.Getenv("MASTER_STATE_DIR")
+	// fence, con varios masters, confirma antes de cada escritura del WAL
+	// que este sigue siendo el líder
+	var fence func() error
 
-	masterAPI := api.NewMasterAPI(registry)
-	jobAPI := api.NewJobAPI(jobManager)
+	// lead pone a correr este master como líder: recupera el estado y
+	// arranca el scheduler y los loops de fondo
+	lead := func() {
+		// estado durable: MASTER_STATE_DIR guarda los jobs en un WAL con
+		// snapshots; al reiniciar se recuperan y se reencola lo que faltaba
+		if stateDir != "" {
+			wal, err := core.OpenWAL(stateDir)
+			if err != nil {
+				log.Fatal("cannot open master state: ", err)
+			}
+			// cuántos jobs terminados guarda el snapshot (100); los más viejos
+			// no vuelven si el master reinicia
+			if v := os.Getenv("MASTER_KEEP_FINISHED_JOBS"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					log.Fatalf("invalid MASTER_KEEP_FINISHED_JOBS %q", v)
+				}
+				wal.KeepFinished = n
+			}
+			wal.Fence = fence
+			pending, err := jobManager.Recover(wal, registry)
+			if err != nil {
+				log.Fatal("cannot recover master state: ", err)
+			}
+			for _, a := range pending {
+				jobManager.EnqueueFn(a)
+			}
+		} else {
+			log.Println("MASTER_STATE_DIR not set, jobs will not survive a master restart")
+		}
+
+		// start scheduler
+		sched.Start()
 
-	// Background: detect worker DOWN
-	go func() {
-		for {
-			registry.DetectDown(5 * time.Second)
-			time.Sleep(2 * time.Second)
-		}
-	}()
-
-	// Background: enqueue ACCEPTED jobs (original watcher logic can remain,
-	// but now BuildTasks + Enqueue are handled in SubmitJob, so watcher can be optional)
-	go func() {
-		// minimal watcher: convert any ACCEPTED job that was missed
-		seen := map[string]bool{}
-		for {
-			jobs := jobManager.List()
-			for _, j := range jobs {
-				if j.State == core.JobAccepted && !seen[j.ID] {
-					assignments := jobManager.BuildTasks(j)
-					for _, a := range assignments {
-						if jobManager.EnqueueFn != nil {
-							jobManager.EnqueueFn(a)
+		// Background: detect worker DOWN
+		go func() {
+			for {
+				registry.DetectDown(5 * time.Second)
+				time.Sleep(2 * time.Second)
+			}
+		}()
+
+		// Background: enqueue ACCEPTED jobs (original watcher logic can remain,
+		// but now BuildTasks + Enqueue are handled in SubmitJob, so watcher can be optional)
+		go func() {
+			// minimal watcher: convert any ACCEPTED job that was missed
+			seen := map[string]bool{}
+			for {
+				jobs := jobManager.List()
+				for _, j := range jobs {
+					if j.State == core.JobAccepted && !seen[j.ID] {
+						assignments := jobManager.BuildTasks(j)
+						for _, a := range assignments {
+							if jobManager.EnqueueFn != nil {
+								jobManager.EnqueueFn(a)
+							}
 						}
+						seen[j.ID] = true
 					}
-					seen[j.ID] = true
 				}
+				time.Sleep(1 * time.Second)
 			}
-			time.Sleep(1 * time.Second)
-		}
-	}()
+		}()
+	}
+
+	masterAPI := api.NewMasterAPI(registry)
+	masterAPI.ReportFn = sched.HandleReport
+	masterAPI.LeaseFn = sched.Lease
+	masterAPI.RenewFn = sched.RenewLeases
+	masterAPI.ReconcileFn = sched.Reconcile
+	masterAPI.CacheFn = jobManager.CacheHeld
+	jobAPI := api.NewJobAPI(jobManager)
 
 	router := api.BuildRouter(masterAPI, jobAPI)
 
-	log.Println("Master listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	// alta disponibilidad: los masters que comparten MASTER_STATE_DIR se
+	// disputan el lease de líder; los que no lo tienen redirigen al líder
+	if stateDir != "" {
+		id := os.Getenv("MASTER_ID")
+		if id == "" {
+			id, _ = os.Hostname()
+		}
+		url := os.Getenv("MASTER_HOST")
+		if url == "" {
+			url = "http://localhost:8080"
+		}
+		elector, err := core.NewElector(stateDir, id, url)
+		if err != nil {
+			log.Fatal("cannot open master state: ", err)
+		}
+		router = api.FollowLeader(router, elector.Leader)
+		fence = elector.Fence
+		go elector.Run(lead, func() {
+			log.Fatal("lost master leadership, exiting")
+		})
+	} else {
+		lead()
+	}
+
+	port := os.Getenv("MASTER_HTTP_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	log.Println("Master listening on :" + port)
+	log.Fatal(http.ListenAndServe(":"+port, router))
+}
+
+func speculationFromEnv(cfg *scheduler.SpeculationConfig) error {
+	if v := os.Getenv("SPECULATION"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid SPECULATION: %v", err)
+		}
+		cfg.Enabled = on
+	}
+	if v := os.Getenv("SPECULATION_QUANTILE"); v != "" {
+		q, err := strconv.ParseFloat(v, 64)
+		if err != nil || q <= 0 || q > 1 {
+			return fmt.Errorf("invalid SPECULATION_QUANTILE %q: must be in (0, 1]", v)
+		}
+		cfg.Quantile = q
+	}
+	if v := os.Getenv("SPECULATION_MULTIPLIER"); v != "" {
+		m, err := strconv.ParseFloat(v, 64)
+		if err != nil || m < 1 {
+			return fmt.Errorf("invalid SPECULATION_MULTIPLIER %q: must be at least 1", v)
+		}
+		cfg.Multiplier = m
+	}
+	return nil
 }
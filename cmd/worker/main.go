@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"log"
 	"net/http"
 	"os"
@@ -11,44 +9,94 @@ import (
 	"batchdag/internal/worker"
 )
 
+// Resources es lo que el worker declara al master al registrarse y en cada
+// heartbeat.
+type Resources struct {
+	Slots    int   `json:"slots"`
+	CPUs     int   `json:"cpus"`
+	MemoryMB int64 `json:"memory_mb"`
+}
+
+// RegisterReq lleva también lo que el worker tiene corriendo y las salidas
+// de shuffle que guarda, para que un master que reinició lo reconcilie, y
+// las particiones persistidas que puede servir a otros jobs.
 type RegisterReq struct {
 	ID   string `json:"id"`
 	Host string `json:"host"`
+	Mode string `json:"mode,omitempty"`
+	Resources
+	Running []worker.RunningAttempt `json:"running,omitempty"`
+	Outputs []string                `json:"outputs,omitempty"`
+	Cached  []string                `json:"cached,omitempty"`
 }
 
 type HBReq struct {
-	ID string `json:"id"`
+	ID     string   `json:"id"`
+	Leases []string `json:"leases,omitempty"`
+	Resources
 }
 
 func main() {
 	workerID := os.Getenv("WORKER_ID")
 	workerHost := os.Getenv("WORKER_HOST")
-	master := os.Getenv("MASTER_URL")
 	port := os.Getenv("WORKER_HTTP_PORT")
 	if port == "" {
 		port = "8081"
 	}
+	// push (por defecto): el master manda las tareas a WORKER_HOST;
+	// pull: el worker las pide al master
+	mode := os.Getenv("WORKER_MODE")
+	pull := mode == "pull"
+	res := Resources{Slots: worker.Slots(), CPUs: worker.CPUs(), MemoryMB: worker.MemoryMB()}
 
 	// Register
-	log.Println("Registering worker", workerID, "at", master+"/register")
-	sendJSON(master+"/register", RegisterReq{ID: workerID, Host: workerHost})
+	log.Println("Registering worker", workerID, "at", worker.MasterURL()+"/register",
+		"with", res.Slots, "slots,", res.CPUs, "cpus,", res.MemoryMB, "MB")
+	register := func() {
+		sendJSON("/register", RegisterReq{
+			ID:        workerID,
+			Host:      workerHost,
+			Mode:      mode,
+			Resources: res,
+			Running:   worker.RunningAttempts(),
+			Outputs:   worker.ShuffleOutputs(),
+			Cached:    worker.CachedPartitions(),
+		})
+	}
+	register()
 
 	// Heartbeat
 	go func() {
 		for {
 			log.Println("sending heartbeat...", workerID)
-			sendJSON(master+"/heartbeat", HBReq{ID: workerID})
+			hb := HBReq{ID: workerID, Resources: res}
+			if pull {
+				hb.Leases = worker.RunningTaskIDs()
+			}
+			// 404: el master no conoce a este worker (reinició)
+			if status, _ := sendJSON("/heartbeat", hb); status == http.StatusNotFound {
+				log.Println("Master does not know worker", workerID, "- registering again")
+				register()
+			}
 			time.Sleep(2 * time.Second)
 		}
 	}()
 
 	http.HandleFunc("/task", worker.TaskHandler)
+	http.HandleFunc("POST /task/cancel", worker.CancelHandler)
+	http.HandleFunc("GET /shuffle/{shuffle}/{task}/{bucket}", worker.ShuffleHandler)
+	http.HandleFunc("POST /shuffle/cleanup", worker.ShuffleCleanupHandler)
+	http.HandleFunc("GET /cache/{key}/{partition}", worker.CacheHandler)
+
+	if pull {
+		log.Println("Worker", workerID, "pulling tasks with", worker.Slots(), "slots")
+		go worker.PullLoop(workerID)
+	}
 
 	log.Println("Worker", workerID, "listening on port", port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
 
-func sendJSON(url string, data interface{}) {
-	b, _ := json.Marshal(data)
-	http.Post(url, "application/json", bytes.NewBuffer(b))
+func sendJSON(path string, data interface{}) (int, error) {
+	return worker.SendToMaster(path, data)
 }
@@ -40,6 +40,23 @@ func (api *JobAPI) SubmitJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// opciones del job, al lado de "stages" en el mismo JSON
+	var opts struct {
+		FailurePolicy string `json:"failure_policy"`
+		Pool          string `json:"pool"`
+		Priority      int    `json:"priority"`
+	}
+	json.Unmarshal(body, &opts)
+	policy, err := core.ParseFailurePolicy(opts.FailurePolicy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Priority < 0 {
+		http.Error(w, "priority must not be negative", http.StatusBadRequest)
+		return
+	}
+
 	// crear Job
 	job := &core.Job{
 		ID:        generateJobID(),
@@ -47,6 +64,10 @@ func (api *JobAPI) SubmitJob(w http.ResponseWriter, r *http.Request) {
 		State:     core.JobAccepted,
 		CreatedAt: time.Now(),
 		Tasks:     make(map[string]*core.JobTask),
+
+		FailurePolicy: policy,
+		Pool:          opts.Pool,
+		Priority:      max(opts.Priority, 1),
 	}
 
 	api.Jobs.Add(job)
@@ -76,6 +97,26 @@ func (api *JobAPI) GetJob(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(j)
 }
 
+// CancelJob cancela un job en curso. Responde 409 si el job ya había
+// terminado.
+func (api *JobAPI) CancelJob(w http.ResponseWriter, r *http.Request) {
+    id := r.PathValue("id")
+    state, ok := api.Jobs.Cancel(id)
+    if !ok && state == "" {
+        http.NotFound(w, r)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if !ok {
+        w.WriteHeader(http.StatusConflict)
+    }
+    json.NewEncoder(w).Encode(map[string]string{
+        "jobId": id,
+        "state": string(state),
+    })
+}
+
 func (api *JobAPI) ListJobs(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(api.Jobs.List())
 }
@@ -0,0 +1,22 @@
+package api
+
+import "net/http"
+
+// FollowLeader deja pasar los pedidos sólo si este master es el líder
+// y ya atiende (ver core.Elector). Un master en standby redirige (307,
+// conserva método y body) al líder, y los workers pasan a hablarle a él; si
+// todavía no hay líder listo responde 503 para que el cliente reintente.
+func FollowLeader(next http.Handler, leader func() (bool, string)) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        self, url := leader()
+        if self {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if url == "" {
+            http.Error(w, "no leader available", http.StatusServiceUnavailable)
+            return
+        }
+        http.Redirect(w, r, url+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+    })
+}
@@ -1,43 +1,93 @@
 package api
 
 import (
+    "context"
     "encoding/json"
     "net/http"
+    "time"
+
     "batchdag/internal/core"
 )
 
 type MasterAPI struct {
     Registry *core.WorkerRegistry
+    // ReportFn (de main) entrega al scheduler los reportes de tareas.
+    ReportFn func(rep *core.TaskReport) bool
+    // LeaseFn y RenewFn (de main) atienden a los workers en modo pull.
+    LeaseFn func(ctx context.Context, workerID string, slots int, wait time.Duration) []core.TaskLease
+    RenewFn func(workerID string, taskIDs []string)
+    // ReconcileFn (de main) adopta lo que cuenta un worker al registrarse.
+    ReconcileFn func(workerID string, running []core.RunningAttempt, outputs []string)
+    // CacheFn (de main) anota las particiones persistidas que guarda el worker.
+    CacheFn func(workerID, host string, held []string)
 }
 
 func NewMasterAPI(reg *core.WorkerRegistry) *MasterAPI {
     return &MasterAPI{Registry: reg}
 }
 
+// RegisterRequest trae, además de la dirección del worker, los recursos que
+// declara (slots, cpus, memory_mb), los intentos que tiene corriendo y las
+// tareas cuya salida de shuffle guarda: si el master reinició, con eso los
+// reconcilia en vez de volver a correr todo. Cached son las particiones de
+// stages con persist que guarda (ver dag.CachePartition).
 type RegisterRequest struct {
-    ID   string `json:"id"`
-    Host string `json:"host"`
+    ID   string          `json:"id"`
+    Host string          `json:"host"`
+    Mode core.WorkerMode `json:"mode,omitempty"`
+    core.WorkerResources
+    Running []core.RunningAttempt `json:"running,omitempty"`
+    Outputs []string              `json:"outputs,omitempty"`
+    Cached  []string              `json:"cached,omitempty"`
 }
 
 func (api *MasterAPI) RegisterWorker(w http.ResponseWriter, r *http.Request) {
     var req RegisterRequest
     json.NewDecoder(r.Body).Decode(&req)
 
-    api.Registry.Register(req.ID, req.Host)
+    if req.Mode != "" && req.Mode != core.WorkerPush && req.Mode != core.WorkerPull {
+        http.Error(w, "invalid mode: "+string(req.Mode), http.StatusBadRequest)
+        return
+    }
+    if req.Slots < 0 || req.CPUs < 0 || req.MemoryMB < 0 {
+        http.Error(w, "invalid resources", http.StatusBadRequest)
+        return
+    }
+    api.Registry.Register(req.ID, req.Host, req.Mode, req.WorkerResources)
+    if api.CacheFn != nil {
+        api.CacheFn(req.ID, req.Host, req.Cached)
+    }
+    if api.ReconcileFn != nil {
+        api.ReconcileFn(req.ID, req.Running, req.Outputs)
+    }
+    api.Registry.Reconciled(req.ID)
 
     w.WriteHeader(http.StatusOK)
     w.Write([]byte("registered"))
 }
 
+// HeartbeatRequest lleva los recursos actuales del worker y, para los
+// workers en modo pull, los IDs de las tareas que tienen en leasing: el
+// heartbeat renueva esos leases. Si el master no conoce al worker (o lo
+// recuperó del WAL) responde 404 y el worker tiene que volver a
+// registrarse.
 type HeartbeatRequest struct {
-    ID string `json:"id"`
+    ID     string   `json:"id"`
+    Leases []string `json:"leases,omitempty"`
+    core.WorkerResources
 }
 
 func (api *MasterAPI) Heartbeat(w http.ResponseWriter, r *http.Request) {
     var req HeartbeatRequest
     json.NewDecoder(r.Body).Decode(&req)
 
-    api.Registry.Heartbeat(req.ID)
+    if !api.Registry.Heartbeat(req.ID, req.WorkerResources) {
+        http.Error(w, "unknown worker, register again", http.StatusNotFound)
+        return
+    }
+    if len(req.Leases) > 0 && api.RenewFn != nil {
+        api.RenewFn(req.ID, req.Leases)
+    }
     w.WriteHeader(http.StatusOK)
     w.Write([]byte("ok"))
 }
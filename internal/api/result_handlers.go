@@ -0,0 +1,150 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "batchdag/internal/core"
+)
+
+// Los resultados se piden aparte del estado del job, paginados:
+//
+//   GET /api/v1/jobs/{id}/results?limit=500&cursor=...
+//   GET /api/v1/jobs/{id}/stages/{stage}/results?limit=500&cursor=...
+//
+// Sin stage se devuelven los de los stages hoja. En JSON la respuesta trae
+// "records" y, si quedan más, "next_cursor". Con ?format=ndjson o
+// Accept: application/x-ndjson se streamea un registro por línea desde el
+// cursor (todos, o hasta limit si viene) y el cursor siguiente va en el
+// trailer X-Next-Cursor cuando se cortó por limit.
+
+const (
+    defaultResultLimit = 1000
+    maxResultLimit     = 10000
+    // tamaño de cada página que se lee del JobManager al streamear
+    ndjsonPageSize = 1000
+)
+
+func (api *JobAPI) GetResults(w http.ResponseWriter, r *http.Request) {
+    api.serveResults(w, r, r.PathValue("id"), "")
+}
+
+func (api *JobAPI) GetStageResults(w http.ResponseWriter, r *http.Request) {
+    api.serveResults(w, r, r.PathValue("id"), r.PathValue("stage"))
+}
+
+func (api *JobAPI) serveResults(w http.ResponseWriter, r *http.Request, jobID, stageID string) {
+    q := r.URL.Query()
+    cur, err := core.DecodeCursor(q.Get("cursor"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    limit := 0
+    if s := q.Get("limit"); s != "" {
+        limit, err = strconv.Atoi(s)
+        if err != nil || limit <= 0 {
+            http.Error(w, "invalid limit", http.StatusBadRequest)
+            return
+        }
+    }
+
+    if wantsNDJSON(r) {
+        api.streamResults(w, jobID, stageID, cur, limit)
+        return
+    }
+
+    if limit == 0 {
+        limit = defaultResultLimit
+    }
+    limit = min(limit, maxResultLimit)
+
+    recs, next, err := api.Jobs.Results(jobID, stageID, cur, limit)
+    if err != nil {
+        resultsError(w, err)
+        return
+    }
+
+    resp := map[string]interface{}{
+        "job_id":  jobID,
+        "records": recs,
+    }
+    if stageID != "" {
+        resp["stage"] = stageID
+    }
+    if j, ok := api.Jobs.Get(jobID); ok {
+        resp["state"] = j.State
+    }
+    if next != nil {
+        resp["next_cursor"] = next.Encode()
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
+
+// streamResults escribe los registros de a páginas, sin armar la respuesta
+// entera en memoria ni tener tomado el lock del JobManager mientras escribe.
+func (api *JobAPI) streamResults(w http.ResponseWriter, jobID, stageID string, cur core.ResultCursor, limit int) {
+    flusher, _ := w.(http.Flusher)
+    enc := json.NewEncoder(w)
+    sent := 0
+    started := false
+
+    for {
+        page := ndjsonPageSize
+        if limit > 0 {
+            page = min(page, limit-sent)
+        }
+        recs, next, err := api.Jobs.Results(jobID, stageID, cur, page)
+        if err != nil {
+            if !started {
+                resultsError(w, err)
+            }
+            return
+        }
+        if !started {
+            w.Header().Set("Content-Type", "application/x-ndjson")
+            // el cursor siguiente sólo se conoce al final; va como trailer
+            w.Header().Set("Trailer", "X-Next-Cursor")
+            started = true
+        }
+        for _, rec := range recs {
+            if err := enc.Encode(rec); err != nil {
+                return
+            }
+        }
+        sent += len(recs)
+        if flusher != nil {
+            flusher.Flush()
+        }
+
+        if next == nil {
+            return
+        }
+        cur = *next
+        if limit > 0 && sent >= limit {
+            w.Header().Set("X-Next-Cursor", cur.Encode())
+            return
+        }
+    }
+}
+
+func wantsNDJSON(r *http.Request) bool {
+    if f := r.URL.Query().Get("format"); f != "" {
+        return f == "ndjson"
+    }
+    return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+func resultsError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, core.ErrJobNotFound), errors.Is(err, core.ErrStageNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    default:
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    }
+}
@@ -9,11 +9,16 @@ func BuildRouter(mapi *MasterAPI, japi *JobAPI) http.Handler {
     mux.HandleFunc("/register", mapi.RegisterWorker)
     mux.HandleFunc("/heartbeat", mapi.Heartbeat)
     mux.HandleFunc("/workers", mapi.ListWorkers)
+    mux.HandleFunc("POST /tasks/report", mapi.ReportTask)
+    mux.HandleFunc("POST /api/v1/tasks/lease", mapi.LeaseTasks)
 
     // jobs
     mux.HandleFunc("POST /api/v1/jobs", japi.SubmitJob)
     mux.HandleFunc("GET /api/v1/jobs", japi.ListJobs)
     mux.HandleFunc("GET /api/v1/jobs/{id}", japi.GetJob)
+    mux.HandleFunc("POST /api/v1/jobs/{id}/cancel", japi.CancelJob)
+    mux.HandleFunc("GET /api/v1/jobs/{id}/results", japi.GetResults)
+    mux.HandleFunc("GET /api/v1/jobs/{id}/stages/{stage}/results", japi.GetStageResults)
 
     return mux
 }
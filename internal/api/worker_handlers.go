@@ -1 +1,70 @@
-package api
\ No newline at end of file
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+
+    "batchdag/internal/core"
+)
+
+// LeaseRequest lo manda un worker en modo pull: cuántos slots libres tiene
+// y cuánto está dispuesto a esperar (long-poll) si no hay tareas.
+type LeaseRequest struct {
+    WorkerID string `json:"worker_id"`
+    Slots    int    `json:"slots"`
+    WaitMs   int    `json:"wait_ms,omitempty"`
+}
+
+const maxLeaseWait = 30 * time.Second
+
+// LeaseTasks entrega tareas a un worker en modo pull. Responde
+// {"leases": [...]}, vacío si no hubo tareas en el tiempo de espera.
+func (api *MasterAPI) LeaseTasks(w http.ResponseWriter, r *http.Request) {
+    var req LeaseRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.WorkerID == "" {
+        http.Error(w, "invalid lease request", http.StatusBadRequest)
+        return
+    }
+    if _, ok := api.Registry.Get(req.WorkerID); !ok {
+        http.Error(w, "unknown worker, register first", http.StatusConflict)
+        return
+    }
+
+    wait := min(time.Duration(req.WaitMs)*time.Millisecond, maxLeaseWait)
+    leases := []core.TaskLease{}
+    if api.LeaseFn != nil {
+        if l := api.LeaseFn(r.Context(), req.WorkerID, req.Slots, wait); l != nil {
+            leases = l
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "leases": leases,
+    })
+}
+
+// ReportTask recibe de un worker cómo terminó un intento de tarea. Responde
+// 409 si el intento ya no es el vigente (timeout, reintento, job cancelado):
+// el worker no tiene que reintentar el reporte. Si el worker todavía no se
+// volvió a registrar después de un reinicio del master responde 503: al
+// registrarse el intento se adopta y el reintento del reporte entra.
+func (api *MasterAPI) ReportTask(w http.ResponseWriter, r *http.Request) {
+    var rep core.TaskReport
+    if err := json.NewDecoder(r.Body).Decode(&rep); err != nil || rep.TaskID == "" {
+        http.Error(w, "invalid report", http.StatusBadRequest)
+        return
+    }
+
+    if api.ReportFn == nil || !api.ReportFn(&rep) {
+        if api.Registry.NeedsRegister(rep.WorkerID) {
+            http.Error(w, "unknown worker, register again", http.StatusServiceUnavailable)
+            return
+        }
+        http.Error(w, "stale report", http.StatusConflict)
+        return
+    }
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte("ok"))
+}
@@ -1,7 +1,24 @@
 package core
 
+import (
+	"encoding/json"
+	"time"
+
+	"batchdag/internal/dag"
+)
+
 // TaskAssignment es una representación neutral (sin dependencias)
 // de una tarea lista para encolar. Esta estructura evita ciclos de import.
+// Splits son los rangos de bytes de los archivos que lee un stage fuente.
+// Input lleva los registros de las dependencias que alimentan la partición;
+// los stages anchos en cambio reciben ShuffleRead para traer su bucket de
+// los workers. ShuffleWrites indica cómo repartir la salida para los hijos
+// anchos y DiscardOutput que no hace falta devolverla al master. Timeout,
+// MemoryMB y CPUs son los del stage y Preferred los workers donde ya está el input
+// de la tarea; Pool y Priority son los del job, para repartir el cluster
+// entre jobs. Los usa el master y no viajan al worker. CacheWrite indica
+// que la tarea guarde su salida (stage con persist) y CacheRead que la tome
+// de la caché en vez de calcularla.
 type TaskAssignment struct {
 	JobID     string                 `json:"job_id"`
 	TaskID    string                 `json:"task_id"`
@@ -10,4 +27,63 @@ type TaskAssignment struct {
 	Attempts  int                    `json:"attempts"`
 	Op        string                 `json:"op,omitempty"`
 	Params    map[string]interface{} `json:"params,omitempty"`
+	Input     []interface{}          `json:"input,omitempty"`
+	Splits    []dag.InputSplit       `json:"splits,omitempty"`
+
+	ShuffleWrites []dag.ShuffleWrite `json:"shuffle_writes,omitempty"`
+	ShuffleRead   *dag.ShuffleRead   `json:"shuffle_read,omitempty"`
+	DiscardOutput bool               `json:"discard_output,omitempty"`
+	Timeout       time.Duration      `json:"timeout,omitempty"`
+	MemoryMB      int64              `json:"memory_mb,omitempty"`
+	CPUs          int                `json:"cpus,omitempty"`
+	Preferred     []string           `json:"preferred,omitempty"`
+	Pool          string             `json:"pool,omitempty"`
+	Priority      int                `json:"priority,omitempty"`
+	CacheWrite    *dag.CacheWrite    `json:"cache_write,omitempty"`
+	CacheRead     *dag.CacheRead     `json:"cache_read,omitempty"`
+}
+
+// Niveles de localidad con que el scheduler ubicó una tarea (JobTask.Locality).
+const (
+	LocalityNode   = "NODE_LOCAL" // en uno de sus workers preferidos
+	LocalityAny    = "ANY"        // en otro worker, vencida la espera
+	LocalityNoPref = "NO_PREF"    // la tarea no tenía workers preferidos
+)
+
+// TaskLease es una tarea entregada a un worker en modo pull. Task es el
+// mismo payload que recibe un worker en push por POST /task; el lease vence
+// en ExpiresAt salvo que el worker lo renueve con su heartbeat.
+type TaskLease struct {
+	Task      json.RawMessage `json:"task"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// TaskReport es lo que informa un worker al master cuando termina un
+// intento de tarea (POST /tasks/report). Status es "ok", "error" o
+// "cancelled"; Output y Samples son los de una tarea exitosa. Infra indica
+// que el error no es del op sino del worker o de la red (leer o escribir un
+// shuffle, leer la caché); CacheMiss, que la tarea no encontró su partición
+// en la caché (ver JobManager.CacheMissed).
+type TaskReport struct {
+	WorkerID  string                   `json:"worker_id"`
+	JobID     string                   `json:"job_id"`
+	TaskID    string                   `json:"task_id"`
+	Attempt   int                      `json:"attempt"`
+	AttemptID string                   `json:"attempt_id"`
+	Status    string                   `json:"status"`
+	Error     string                   `json:"error,omitempty"`
+	Output    []interface{}            `json:"output,omitempty"`
+	Samples   map[string][]interface{} `json:"samples,omitempty"`
+	Persisted bool                     `json:"persisted,omitempty"`
+	Infra     bool                     `json:"infra,omitempty"`
+	CacheMiss bool                     `json:"cache_miss,omitempty"`
+}
+
+// RunningAttempt es un intento que un worker dice tener corriendo cuando se
+// vuelve a registrar (ver WorkerRegistry.Heartbeat).
+type RunningAttempt struct {
+	JobID     string `json:"job_id"`
+	TaskID    string `json:"task_id"`
+	Attempt   int    `json:"attempt"`
+	AttemptID string `json:"attempt_id"`
 }
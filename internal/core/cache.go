@@ -0,0 +1,260 @@
+package core
+
+import (
+	"log"
+	"sort"
+
+	"batchdag/internal/dag"
+)
+
+// Las tareas de un stage con persist (ver dag.Stage.Persist) dejan su
+// salida guardada en el worker donde corrieron, bajo la clave del stage
+// (dag.DAG.CacheKey). El master lleva en cacheCatalog qué workers tienen
+// cada partición: se arma con los reportes de esas tareas y con lo que cada
+// worker declara al registrarse, y se olvida lo de un worker caído.
+//
+// Al construir un job, un stage con persist cuya clave ya tiene todas sus
+// particiones en el catálogo se lee de la caché (StageStatus.FromCache) en
+// vez de calcularse, y los stages anteriores que sólo hacían falta para él
+// quedan CACHED sin correr. Si después una partición que el stage todavía
+// no leyó deja de estar en la caché (el worker cayó o la desalojó), el
+// stage deja de leerse de la caché y se recalcula por linaje (ver
+// replanLocked).
+
+// cacheCatalog: clave -> partición -> workerID -> host del worker.
+type cacheCatalog map[string]map[int]map[string]string
+
+func (c cacheCatalog) add(key string, p int, workerID, host string) {
+	if c[key] == nil {
+		c[key] = make(map[int]map[string]string)
+	}
+	if c[key][p] == nil {
+		c[key][p] = make(map[string]string)
+	}
+	c[key][p][workerID] = host
+}
+
+func (c cacheCatalog) dropWorker(workerID string) {
+	for key, parts := range c {
+		for p, holders := range parts {
+			delete(holders, workerID)
+			if len(holders) == 0 {
+				delete(parts, p)
+			}
+		}
+		if len(parts) == 0 {
+			delete(c, key)
+		}
+	}
+}
+
+// dropPartition olvida la partición p de key en todos los workers.
+func (c cacheCatalog) dropPartition(key string, p int) {
+	delete(c[key], p)
+	if len(c[key]) == 0 {
+		delete(c, key)
+	}
+}
+
+// complete indica si las parts particiones de key están en algún worker.
+func (c cacheCatalog) complete(key string, parts int) bool {
+	for p := 0; p < parts; p++ {
+		if len(c[key][p]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// holders devuelve, ordenados por ID, los workers que tienen la partición
+// p de key y sus hosts.
+func (c cacheCatalog) holders(key string, p int) (ids, hosts []string) {
+	for id := range c[key][p] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		hosts = append(hosts, c[key][p][id])
+	}
+	return ids, hosts
+}
+
+// CacheHeld reemplaza lo que el catálogo tiene en workerID por lo que el
+// worker dice guardar al registrarse (held, ver dag.CachePartition): si
+// reinició, ya no tiene lo que guardaba en memoria.
+func (m *JobManager) CacheHeld(workerID, host string, held []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cache.dropWorker(workerID)
+	for _, s := range held {
+		if key, p, ok := dag.ParseCachePartition(s); ok {
+			m.cache.add(key, p, workerID, host)
+		}
+	}
+}
+
+// planCacheLocked calcula la clave de cada stage con persist del job y
+// decide cuáles se leen de la caché y cuáles no hace falta correr. Lo llama
+// BuildTasks con m.mu tomado, antes de lanzar los primeros stages.
+func (m *JobManager) planCacheLocked(job *Job) {
+	cached := map[string]bool{}
+	for id, st := range job.DAG.Stages {
+		if st.Persist == "" {
+			continue
+		}
+		key, err := job.DAG.CacheKey(id)
+		if err != nil {
+			log.Printf("job %s: stage %s will not be persisted: %v\n", job.ID, id, err)
+			continue
+		}
+		job.Stages[id].CacheKey = key
+		cached[id] = m.cache.complete(key, job.DAG.NumPartitions(st))
+	}
+	if len(cached) == 0 {
+		return
+	}
+
+	// un stage hace falta si produce el resultado del job o si lo lee
+	// algún hijo que hace falta y no sale de la caché
+	needed := map[string]bool{}
+	var need func(id string) bool
+	need = func(id string) bool {
+		if n, ok := needed[id]; ok {
+			return n
+		}
+		n, children := false, 0
+		for _, child := range job.DAG.Stages {
+			if !dependsOn(child, id) {
+				continue
+			}
+			children++
+			if !cached[child.ID] && need(child.ID) {
+				n = true
+			}
+		}
+		n = n || children == 0
+		needed[id] = n
+		return n
+	}
+
+	reused, skipped := 0, 0
+	for id := range job.DAG.Stages {
+		ss := job.Stages[id]
+		switch {
+		case !need(id):
+			ss.State = StageCached
+			skipped++
+		case cached[id]:
+			ss.FromCache = true
+			reused++
+		}
+	}
+	if reused > 0 {
+		log.Printf("job %s: reading %d stages from cache, skipping %d stages\n", job.ID, reused, skipped)
+	}
+}
+
+// cacheReadLocked indica a la partición p de un stage que se lee de la
+// caché dónde está guardada, y devuelve también los workers que la tienen.
+func (m *JobManager) cacheReadLocked(ss *StageStatus, p int) (*dag.CacheRead, []string) {
+	ids, hosts := m.cache.holders(ss.CacheKey, p)
+	return &dag.CacheRead{Key: ss.CacheKey, Hosts: hosts}, ids
+}
+
+// CacheMissed reacciona a una tarea que no pudo leer su partición de la
+// caché (ningún worker de los del catálogo la tenía): la partición se
+// olvida del catálogo y su stage se recalcula por linaje. Devuelve, como
+// WorkerLost, los assignments a encolar y las tareas cuyos intentos hay que
+// descartar, y false si el stage ya no se lee de la caché.
+func (m *JobManager) CacheMissed(jobID, taskID string) ([]*TaskAssignment, []string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[jobID]
+	if !ok || j.State != JobRunning {
+		return nil, nil, false
+	}
+	t, ok := j.Tasks[taskID]
+	if !ok {
+		return nil, nil, false
+	}
+	ss := j.Stages[t.StageID]
+	if !ss.FromCache || ss.State != StageRunning {
+		return nil, nil, false
+	}
+	m.cache.dropPartition(ss.CacheKey, t.Partition)
+	out, voided := m.replanLocked(j, t.StageID)
+	m.updateProgressLocked(j)
+	m.persistLocked(j)
+	return out, voided, true
+}
+
+// uncachedLocked devuelve, ordenados, los stages del job que se leen de la
+// caché y tienen alguna partición sin terminar que ya no está en ningún
+// worker.
+func (m *JobManager) uncachedLocked(job *Job) []string {
+	var ids []string
+	for id, ss := range job.Stages {
+		if !ss.FromCache || ss.State != StageRunning {
+			continue
+		}
+		for p := 0; p < ss.Partitions; p++ {
+			t, ok := job.Tasks[taskID(job.ID, id, p)]
+			if (!ok || t.Status != "DONE") && len(m.cache[ss.CacheKey][p]) == 0 {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// replanLocked deja de leer stageID de la caché y lo recalcula por linaje:
+// sus particiones sin terminar vuelven a PENDING (sus intentos se
+// descartan), los stages anteriores que se habían salteado (CACHED) vuelven
+// a hacer falta, leídos de la caché si la tienen completa, y se lanzan los
+// que ya pueden correr. El stage corre de nuevo cuando terminan sus
+// dependencias (ver stageDoneLocked). Devuelve los assignments a encolar y
+// las tareas cuyos intentos hay que descartar. Debe llamarse con m.mu
+// tomado.
+func (m *JobManager) replanLocked(job *Job, stageID string) ([]*TaskAssignment, []string) {
+	log.Printf("job %s: cached output of stage %s is gone, recomputing it from its lineage\n", job.ID, stageID)
+	ss := job.Stages[stageID]
+	ss.FromCache = false
+	ss.State = StagePending
+	var voided []string
+	for p := 0; p < ss.Partitions; p++ {
+		if t, ok := job.Tasks[taskID(job.ID, stageID, p)]; ok && t.Status != "DONE" {
+			t.Status = "PENDING"
+			t.live = nil
+			job.touch(t)
+			voided = append(voided, t.ID)
+		}
+	}
+
+	revived := []string{stageID}
+	for i := 0; i < len(revived); i++ {
+		if job.Stages[revived[i]].FromCache {
+			continue
+		}
+		for _, dep := range job.DAG.Stages[revived[i]].Dependencies {
+			ds := job.Stages[dep]
+			if ds.State != StageCached {
+				continue
+			}
+			ds.State = StagePending
+			ds.FromCache = ds.CacheKey != "" && m.cache.complete(ds.CacheKey, job.DAG.NumPartitions(job.DAG.Stages[dep]))
+			revived = append(revived, dep)
+		}
+	}
+	sort.Strings(revived)
+
+	var out []*TaskAssignment
+	for _, id := range revived {
+		st := job.DAG.Stages[id]
+		if s := job.Stages[id]; s.State == StagePending && (s.FromCache || m.depsDoneLocked(job, st)) {
+			out = append(out, m.launchStageLocked(job, st)...)
+		}
+	}
+	return out, voided
+}
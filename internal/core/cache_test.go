@@ -0,0 +1,102 @@
+package core
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"batchdag/internal/dag"
+)
+
+// newCachedJob arma un job s1 -> s2 -> s3 de dos particiones en el que s2
+// (persist) está en la caché: w1 tiene la partición 0 y w2 la 1. s2 se lee
+// de la caché y s1 no hace falta correrlo.
+func newCachedJob(t *testing.T, m *JobManager) *Job {
+	t.Helper()
+	d := dag.New()
+	d.AddStage(&dag.Stage{ID: "s1", Op: "map", Params: map[string]interface{}{"fn": "to_lower"}, Partitions: 2, Persist: dag.PersistMemory})
+	d.AddStage(&dag.Stage{ID: "s2", Op: "map", Params: map[string]interface{}{"fn": "to_upper"}, Partitions: 2, Persist: dag.PersistMemory, Dependencies: []string{"s1"}})
+	d.AddStage(&dag.Stage{ID: "s3", Op: "map", Params: map[string]interface{}{"fn": "to_lower"}, Partitions: 2, Dependencies: []string{"s2"}})
+	key, err := d.CacheKey("s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.CacheHeld("w1", "http://w1", []string{dag.CachePartition(key, 0)})
+	m.CacheHeld("w2", "http://w2", []string{dag.CachePartition(key, 1)})
+
+	job := &Job{ID: "job-1", DAG: d, State: JobAccepted, CreatedAt: time.Now(), Priority: 1}
+	m.Add(job)
+	if as := m.BuildTasks(job); len(as) != 2 || as[0].StageID != "s2" {
+		t.Fatalf("built %d tasks, want the 2 partitions of s2", len(as))
+	}
+	if !job.Stages["s2"].FromCache || job.Stages["s1"].State != StageCached {
+		t.Fatalf("s2 is not read from cache or s1 is not skipped")
+	}
+	return job
+}
+
+// launched devuelve, ordenados, los IDs de las tareas de as.
+func launched(as []*TaskAssignment) []string {
+	ids := make([]string, 0, len(as))
+	for _, a := range as {
+		ids = append(ids, a.TaskID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestCacheMissRecomputesFromLineage(t *testing.T) {
+	m := NewJobManager()
+	job := newCachedJob(t, m)
+
+	tid := taskID("job-1", "s2", 0)
+	m.StartAttempt("job-1", tid, "a1")
+	next, voided, ok := m.CacheMissed("job-1", tid)
+	if !ok {
+		t.Fatalf("cache miss on a stage read from cache was not handled")
+	}
+	if got := launched(next); len(got) != 2 || got[0] != taskID("job-1", "s1", 0) {
+		t.Errorf("launched %v, want the partitions of s1", got)
+	}
+	if len(voided) != 2 {
+		t.Errorf("voided %v, want both partitions of s2", voided)
+	}
+	ss := job.Stages["s2"]
+	if ss.FromCache || ss.State != StagePending || job.Stages["s1"].State != StageRunning {
+		t.Errorf("s2 = %s (from cache %v), s1 = %s; want s2 PENDING recomputed after s1", ss.State, ss.FromCache, job.Stages["s1"].State)
+	}
+	if len(job.Tasks[tid].live) != 0 {
+		t.Errorf("the attempt that missed the cache is still live")
+	}
+	if _, _, ok := m.CacheMissed("job-1", tid); ok {
+		t.Errorf("a second cache miss replanned a stage no longer read from cache")
+	}
+}
+
+func TestWorkerLostRecomputesCachedStage(t *testing.T) {
+	m := NewJobManager()
+	job := newCachedJob(t, m)
+
+	// la partición 1 ya se leyó de w2; la 0 estaba sólo en w1
+	done := taskID("job-1", "s2", 1)
+	m.StartAttempt("job-1", done, "a1")
+	if !m.CompleteTask("job-1", done, "a1", func(jt *JobTask) { jt.Result = []interface{}{"x"} }) {
+		t.Fatalf("task %s was not completed", done)
+	}
+
+	next, voided := m.WorkerLost("w1")
+	if got := launched(next); len(got) != 2 || got[0] != taskID("job-1", "s1", 0) {
+		t.Errorf("launched %v, want the partitions of s1", got)
+	}
+	if len(voided) != 1 || voided[0] != taskID("job-1", "s2", 0) {
+		t.Errorf("voided %v, want only the unread partition of s2", voided)
+	}
+	if ss := job.Stages["s2"]; ss.FromCache || ss.Done != 1 || job.Tasks[done].Status != "DONE" {
+		t.Errorf("s2 = from cache %v with %d done, want recomputed keeping its done partition", ss.FromCache, ss.Done)
+	}
+
+	// sin nada más en la caché, perder w2 no cambia nada
+	if next, voided := m.WorkerLost("w2"); len(next) != 0 || len(voided) != 0 {
+		t.Errorf("losing w2 launched %d tasks and voided %v", len(next), voided)
+	}
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"batchdag/internal/dag"
+)
+
+// Cuando terminan todas las particiones de un stage sink, el master publica
+// su salida: el part file del intento aceptado de cada partición pasa de
+//
+//	<path>/_temporary/<job_id>/<task_id>-<attempt_id>/
+//
+// al directorio de salida, seguido de _SUCCESS. El stage sigue RUNNING
+// mientras tanto; el commit corre fuera de m.mu (ver commitSink) y recién
+// después el stage queda DONE o FAILED.
+//
+// Los part files se juntan primero en <path>/_temporary/<job_id>/_commit y
+// sólo entonces se cambian por los de una corrida anterior, que se apartan
+// en _temporary/<job_id>/_previous: si algo falla a mitad de camino se
+// vuelve a dejar la salida anterior tal como estaba. Cada job usa sólo su
+// subdirectorio de _temporary, así dos jobs que escriben en el mismo path
+// no se borran los intentos.
+
+// sinkCommit es lo que hace falta para publicar la salida de un stage sink.
+type sinkCommit struct {
+	jobID   string
+	stageID string
+	dir     string
+	parts   []sinkPart
+}
+
+// sinkPart es el part file de una partición: dónde lo dejó el intento
+// aceptado y dónde tiene que quedar.
+type sinkPart struct {
+	taskID  string
+	tmp     string
+	final   string
+	records interface{}
+}
+
+// sinkCommitLocked arma el commit del stage sink st, cuyas particiones ya
+// terminaron. Debe llamarse con m.mu tomado.
+func (m *JobManager) sinkCommitLocked(job *Job, st *dag.Stage) (*sinkCommit, error) {
+	dir, _ := st.Params["path"].(string)
+	c := &sinkCommit{jobID: job.ID, stageID: st.ID, dir: dir}
+	for p := 0; p < job.Stages[st.ID].Partitions; p++ {
+		t, ok := job.Tasks[taskID(job.ID, st.ID, p)]
+		if !ok {
+			return nil, fmt.Errorf("missing task for partition %d", p)
+		}
+		tmp, records, err := sinkFile(t)
+		if err != nil {
+			return nil, err
+		}
+		c.parts = append(c.parts, sinkPart{
+			taskID:  t.ID,
+			tmp:     tmp,
+			final:   filepath.Join(dir, dag.PartFileName(st.Op, p)),
+			records: records,
+		})
+	}
+	return c, nil
+}
+
+// commitSink publica la salida de c (sin m.mu: es I/O) y después, con el
+// lock, deja el stage DONE y lanza sus hijos, o lo marca FAILED.
+func (m *JobManager) commitSink(c *sinkCommit) {
+	err := c.run()
+
+	m.mu.Lock()
+	job, ok := m.jobs[c.jobID]
+	if !ok || job.State.Finished() {
+		m.mu.Unlock()
+		if err == nil {
+			log.Printf("job %s: stage %s committed after the job ended\n", c.jobID, c.stageID)
+		}
+		return
+	}
+	var next []*TaskAssignment
+	if err != nil {
+		m.failStageLocked(job, c.stageID, "commit failed: "+err.Error())
+	} else {
+		for _, part := range c.parts {
+			if t, ok := job.Tasks[part.taskID]; ok {
+				t.Result = []interface{}{
+					map[string]interface{}{"file": part.final, "records": part.records},
+				}
+				job.touch(t)
+			}
+		}
+		next = m.stageDoneLocked(job, c.stageID)
+	}
+	m.updateProgressLocked(job)
+	m.persistLocked(job)
+	failed := job.State == JobFailed
+	m.mu.Unlock()
+
+	m.afterUpdate(c.jobID, failed, next)
+}
+
+// run hace el commit en disco (ver arriba). Si falla, la salida anterior
+// queda como estaba; los part files ya juntados en _commit se retoman si
+// se vuelve a correr.
+func (c *sinkCommit) run() error {
+	work := filepath.Join(c.dir, dag.SinkTempDir, c.jobID)
+	staging := filepath.Join(work, "_commit")
+	previous := filepath.Join(work, "_previous")
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		return err
+	}
+	for _, part := range c.parts {
+		staged := filepath.Join(staging, filepath.Base(part.final))
+		if err := os.Rename(part.tmp, staged); err != nil {
+			if _, serr := os.Stat(staged); serr != nil {
+				return err
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(staging, dag.SuccessFile), nil, 0o644); err != nil {
+		return err
+	}
+
+	// apartar la salida anterior; _SUCCESS primero, así nadie la toma por
+	// completa mientras se cambia
+	old, _ := filepath.Glob(filepath.Join(c.dir, "part-*"))
+	old = append([]string{filepath.Join(c.dir, dag.SuccessFile)}, old...)
+	if err := os.MkdirAll(previous, 0o755); err != nil {
+		return err
+	}
+	var moved, placed []string
+	undo := func(err error) error {
+		for _, f := range placed {
+			os.Rename(f, filepath.Join(staging, filepath.Base(f)))
+		}
+		for _, f := range moved {
+			os.Rename(filepath.Join(previous, filepath.Base(f)), f)
+		}
+		return err
+	}
+	for _, f := range old {
+		if err := os.Rename(f, filepath.Join(previous, filepath.Base(f))); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return undo(err)
+		}
+		moved = append(moved, f)
+	}
+
+	// poner la nueva; _SUCCESS al final
+	for _, part := range c.parts {
+		if err := os.Rename(filepath.Join(staging, filepath.Base(part.final)), part.final); err != nil {
+			return undo(err)
+		}
+		placed = append(placed, part.final)
+	}
+	success := filepath.Join(c.dir, dag.SuccessFile)
+	if err := os.Rename(filepath.Join(staging, dag.SuccessFile), success); err != nil {
+		return undo(err)
+	}
+
+	if err := os.RemoveAll(work); err != nil {
+		log.Printf("job %s: could not remove %s: %v\n", c.jobID, work, err)
+	}
+	// _temporary sólo se borra si ningún otro job lo está usando
+	os.Remove(filepath.Join(c.dir, dag.SinkTempDir))
+	return nil
+}
+
+// sinkFile lee del resultado de una tarea sink el part file que escribió.
+func sinkFile(t *JobTask) (string, interface{}, error) {
+	if len(t.Result) == 1 {
+		if rec, ok := t.Result[0].(map[string]interface{}); ok {
+			if f, ok := rec["file"].(string); ok && f != "" {
+				return f, rec["records"], nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("task %s did not report its part file", t.ID)
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"batchdag/internal/dag"
+)
+
+// writeAttempt deja el part file de un intento donde lo escribe el worker.
+func writeAttempt(t *testing.T, dir, jobID, task string, p int, data string) string {
+	t.Helper()
+	tmp := filepath.Join(dir, dag.SinkTempDir, jobID, task+"-a1", dag.PartFileName("write_text", p))
+	if err := os.MkdirAll(filepath.Dir(tmp), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return tmp
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSinkCommitReplacesPreviousOutput(t *testing.T) {
+	dir := t.TempDir()
+	final := filepath.Join(dir, "part-00000.txt")
+	os.WriteFile(final, []byte("old\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "part-00001.txt"), []byte("old\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, dag.SuccessFile), nil, 0o644)
+
+	// otro job escribiendo en el mismo path
+	other := writeAttempt(t, dir, "job-2", "job-2-s1-p0", 0, "other\n")
+
+	c := &sinkCommit{jobID: "job-1", stageID: "s1", dir: dir, parts: []sinkPart{{
+		taskID: "job-1-s1-p0",
+		tmp:    writeAttempt(t, dir, "job-1", "job-1-s1-p0", 0, "new\n"),
+		final:  final,
+	}}}
+	if err := c.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, final); got != "new\n" {
+		t.Errorf("part file = %q, want the new output", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "part-00001.txt")); !os.IsNotExist(err) {
+		t.Errorf("stale part file of the previous run was kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, dag.SuccessFile)); err != nil {
+		t.Errorf("missing %s: %v", dag.SuccessFile, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, dag.SinkTempDir, "job-1")); !os.IsNotExist(err) {
+		t.Errorf("the job's temporary directory was not removed")
+	}
+	if got := readFile(t, other); got != "other\n" {
+		t.Errorf("another job's attempt was touched: %q", got)
+	}
+}
+
+func TestSinkCommitFailureKeepsPreviousOutput(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "part-00000.txt"), []byte("old0\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "part-00001.txt"), []byte("old1\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, dag.SuccessFile), nil, 0o644)
+
+	tmp0 := writeAttempt(t, dir, "job-1", "job-1-s1-p0", 0, "new0\n")
+	c := &sinkCommit{jobID: "job-1", stageID: "s1", dir: dir, parts: []sinkPart{
+		{taskID: "job-1-s1-p0", tmp: tmp0, final: filepath.Join(dir, "part-00000.txt")},
+		// el part file de la partición 1 no está
+		{taskID: "job-1-s1-p1", tmp: filepath.Join(dir, "missing"), final: filepath.Join(dir, "part-00001.txt")},
+	}}
+	if err := c.run(); err == nil {
+		t.Fatal("commit with a missing part file succeeded")
+	}
+
+	for p, want := range []string{"old0\n", "old1\n"} {
+		if got := readFile(t, filepath.Join(dir, dag.PartFileName("write_text", p))); got != want {
+			t.Errorf("partition %d = %q after a failed commit, want %q", p, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, dag.SuccessFile)); err != nil {
+		t.Errorf("previous %s was removed: %v", dag.SuccessFile, err)
+	}
+
+	// el reintento retoma lo que ya se había juntado
+	c.parts[1].tmp = writeAttempt(t, dir, "job-1", "job-1-s1-p1", 1, "new1\n")
+	if err := c.run(); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range []string{"new0\n", "new1\n"} {
+		if got := readFile(t, filepath.Join(dir, dag.PartFileName("write_text", p))); got != want {
+			t.Errorf("partition %d = %q after the retry, want %q", p, got, want)
+		}
+	}
+}
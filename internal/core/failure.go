@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+	"log"
+)
+
+// FailurePolicy decide qué pasa con un job cuando un stage falla.
+type FailurePolicy string
+
+const (
+	// FailFast: el primer stage fallido hace fallar el job y se cancela lo
+	// que quede en cola o corriendo.
+	FailFast FailurePolicy = "fail_fast"
+	// ContinueOnFailure: sólo se saltean los stages que dependen del fallido;
+	// las ramas independientes siguen y el job termina FAILED al final.
+	ContinueOnFailure FailurePolicy = "continue"
+)
+
+// ParseFailurePolicy valida el failure_policy de un submit; vacío es
+// fail_fast.
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch FailurePolicy(s) {
+	case "", FailFast:
+		return FailFast, nil
+	case ContinueOnFailure:
+		return ContinueOnFailure, nil
+	}
+	return "", fmt.Errorf("unknown failure_policy: %q", s)
+}
+
+// FailTask marca una tarea como fallida en forma definitiva (agotó sus
+// reintentos): guarda el error y el worker, hace fallar su stage y aplica la
+// política de fallos del job.
+func (m *JobManager) FailTask(jobID, taskID, workerID, errMsg string) {
+	m.mu.Lock()
+	j, ok := m.jobs[jobID]
+	if !ok || j.State.Finished() {
+		m.mu.Unlock()
+		return
+	}
+	t, ok := j.Tasks[taskID]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	t.Status = "FAILED"
+	t.Error = errMsg
+	if workerID != "" {
+		t.AssignedTo = workerID
+	}
+	j.touch(t)
+
+	msg := fmt.Sprintf("task %s failed on %s: %s", t.ID, t.AssignedTo, errMsg)
+	if t.AssignedTo == "" {
+		// nunca llegó a un worker (ver Scheduler.failUnscheduled)
+		msg = fmt.Sprintf("task %s failed: %s", t.ID, errMsg)
+	}
+	m.failStageLocked(j, t.StageID, msg)
+	m.updateProgressLocked(j)
+	m.persistLocked(j)
+	failed := j.State == JobFailed
+	m.mu.Unlock()
+
+	if failed && m.CancelFn != nil {
+		m.CancelFn(jobID)
+	}
+}
+
+// failStageLocked marca el stage como FAILED y saltea los stages que
+// dependen de él. Con fail_fast además detiene el job.
+// Debe llamarse con m.mu tomado.
+func (m *JobManager) failStageLocked(job *Job, stageID, msg string) {
+	ss, ok := job.Stages[stageID]
+	if !ok || ss.State == StageFailed {
+		return
+	}
+	log.Printf("job %s: stage %s failed: %s\n", job.ID, stageID, msg)
+	ss.State = StageFailed
+	ss.Error = msg
+	if job.Error == "" {
+		job.Error = "stage " + stageID + ": " + msg
+	}
+
+	m.skipDependentsLocked(job)
+	if job.FailurePolicy != ContinueOnFailure {
+		stopJobLocked(job, JobFailed)
+	}
+}
+
+// skipDependentsLocked marca SKIPPED los stages pendientes que dependen,
+// directa o transitivamente, de un stage fallido o salteado.
+func (m *JobManager) skipDependentsLocked(job *Job) {
+	for changed := true; changed; {
+		changed = false
+		for id, st := range job.DAG.Stages {
+			ss := job.Stages[id]
+			if ss.State != StagePending {
+				continue
+			}
+			for _, dep := range st.Dependencies {
+				if s := job.Stages[dep].State; s == StageFailed || s == StageSkipped {
+					ss.State = StageSkipped
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
+
+// stopJobLocked deja el job en state (FAILED o CANCELLED): las tareas sin
+// terminar quedan CANCELLED, igual que los stages pendientes o en curso.
+// Debe llamarse con m.mu tomado.
+func stopJobLocked(job *Job, state JobState) {
+	job.State = state
+	for _, t := range job.Tasks {
+		if t.Status != "DONE" && t.Status != "FAILED" {
+			t.Status = "CANCELLED"
+			job.touch(t)
+		}
+	}
+	for _, ss := range job.Stages {
+		if ss.State == StagePending || ss.State == StageRunning {
+			ss.State = StageCancelled
+		}
+	}
+}
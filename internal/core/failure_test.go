@@ -0,0 +1,79 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFailurePolicies(t *testing.T) {
+	// a -> b y, en otra rama, c -> d; falla una tarea de a
+	deps := map[string][]string{"a": nil, "b": {"a"}, "c": nil, "d": {"c"}}
+	cases := []struct {
+		policy  FailurePolicy
+		running JobState // estado del job después del fallo
+		stages  map[string]StageState
+		sibling string // la otra tarea de a
+		stopped bool   // se llamó a CancelFn
+		final   JobState
+	}{
+		{
+			policy:  FailFast,
+			running: JobFailed,
+			stages:  map[string]StageState{"a": StageFailed, "b": StageSkipped, "c": StageCancelled, "d": StageCancelled},
+			sibling: "CANCELLED",
+			stopped: true,
+			final:   JobFailed,
+		},
+		{
+			policy:  ContinueOnFailure,
+			running: JobRunning,
+			stages:  map[string]StageState{"a": StageFailed, "b": StageSkipped, "c": StageRunning, "d": StagePending},
+			sibling: "PENDING",
+			final:   JobFailed,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			m := NewJobManager()
+			job := newDAGJob(deps)
+			job.FailurePolicy = tc.policy
+			m.Add(job)
+			m.BuildTasks(job)
+			var stopped []string
+			m.CancelFn = func(jobID string) { stopped = append(stopped, jobID) }
+
+			failed := taskID("job-1", "a", 0)
+			m.FailTask("job-1", failed, "w1", "boom")
+
+			if job.State != tc.running || tc.stopped != (len(stopped) > 0) {
+				t.Fatalf("job = %s (stopped %v), want %s (stopped %v)", job.State, stopped, tc.running, tc.stopped)
+			}
+			for id, want := range tc.stages {
+				if got := job.Stages[id].State; got != want {
+					t.Errorf("stage %s = %s, want %s", id, got, want)
+				}
+			}
+			task := job.Tasks[failed]
+			if task.Status != "FAILED" || task.AssignedTo != "w1" || task.Error != "boom" {
+				t.Errorf("failed task = %s on %s with %q, want FAILED on w1 with boom", task.Status, task.AssignedTo, task.Error)
+			}
+			if got := job.Tasks[taskID("job-1", "a", 1)].Status; got != tc.sibling {
+				t.Errorf("other task of a = %s, want %s", got, tc.sibling)
+			}
+			if job.Error == "" {
+				t.Errorf("the job does not record why it failed")
+			}
+
+			// con continue la rama independiente termina antes de fallar el job
+			if tc.policy == ContinueOnFailure {
+				if as := finishStage(t, m, "c"); !reflect.DeepEqual(stagesOf(as), []string{"d"}) {
+					t.Fatalf("finishing c launched %v, want d", stagesOf(as))
+				}
+				finishStage(t, m, "d")
+			}
+			if job.State != tc.final {
+				t.Errorf("job ended %s, want %s", job.State, tc.final)
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package core
 
 import (
-	"fmt"
+	"log"
 	"sync"
 	"time"
 
@@ -11,51 +11,115 @@ import (
 type JobState string
 
 const (
-	JobAccepted JobState = "ACCEPTED"
-	JobRunning  JobState = "RUNNING"
-	JobFailed   JobState = "FAILED"
-	JobSuccess  JobState = "SUCCEEDED"
+	JobAccepted  JobState = "ACCEPTED"
+	JobRunning   JobState = "RUNNING"
+	JobFailed    JobState = "FAILED"
+	JobSuccess   JobState = "SUCCEEDED"
+	JobCancelled JobState = "CANCELLED"
 )
 
+// Finished indica si el job ya no va a ejecutar más tareas.
+func (s JobState) Finished() bool {
+	return s == JobSuccess || s == JobFailed || s == JobCancelled
+}
+
 type Job struct {
-	ID        string              `json:"id"`
-	DAG       *dag.DAG            `json:"dag"`
-	State     JobState            `json:"state"`
-	CreatedAt time.Time           `json:"created_at"`
-	Tasks     map[string]*JobTask `json:"tasks"`
-	Progress  float32             `json:"progress"`
+	ID        string                  `json:"id"`
+	DAG       *dag.DAG                `json:"dag"`
+	State     JobState                `json:"state"`
+	CreatedAt time.Time               `json:"created_at"`
+	Tasks     map[string]*JobTask     `json:"tasks"`
+	Stages    map[string]*StageStatus `json:"stages"`
+	Progress  float32                 `json:"progress"`
+
+	FailurePolicy FailurePolicy `json:"failure_policy"`
+	Error         string        `json:"error,omitempty"`
+
+	// Pool y Priority deciden qué parte del cluster le toca al job frente a
+	// los demás: Priority es su peso dentro del pool (1 por defecto).
+	Pool     string `json:"pool,omitempty"`
+	Priority int    `json:"priority"`
+
+	dirty map[*JobTask]bool // tareas que cambiaron y falta anotar en el WAL
+	ended bool              // ya se avisó a FinishedFn
 }
 
 type JobTask struct {
-	ID         string        `json:"id"`
-	StageID    string        `json:"stage_id"`
-	Partition  int           `json:"partition"`
-	Status     string        `json:"status"`
-	Attempts   int           `json:"attempts"`
-	AssignedTo string        `json:"assigned_to,omitempty"`
-	Result     []interface{} `json:"result,omitempty"`
+	ID         string                   `json:"id"`
+	StageID    string                   `json:"stage_id"`
+	Partition  int                      `json:"partition"`
+	Status     string                   `json:"status"`
+	Attempts   int                      `json:"attempts"`
+	AssignedTo string                   `json:"assigned_to,omitempty"`
+	OutputHost string                   `json:"output_host,omitempty"`
+	Locality   string                   `json:"locality,omitempty"`
+	Error      string                   `json:"error,omitempty"`
+	History    []TaskAttempt            `json:"history,omitempty"`
+	Persisted  bool                     `json:"persisted,omitempty"` // su salida quedó en la caché
+	Result     []interface{}            `json:"-"`
+	KeySamples map[string][]interface{} `json:"-"`
+
+	// AttemptID es el intento cuyo resultado se aceptó (ver CompleteTask);
+	// live son los intentos despachados que todavía pueden completarla.
+	AttemptID string `json:"attempt_id,omitempty"`
+	live      map[string]bool
+}
+
+// TaskAttempt es un intento ya terminado de una tarea: dónde corrió, cómo
+// terminó (DONE, FAILED, LOST si se perdió el worker o el lease, CANCELLED
+// si ganó otro intento) y cuánto tardó.
+type TaskAttempt struct {
+	Attempt     int    `json:"attempt"`
+	AttemptID   string `json:"attempt_id,omitempty"`
+	Worker      string `json:"worker"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	DurationMs  int64  `json:"duration_ms"`
+	Speculative bool   `json:"speculative,omitempty"`
 }
 
 type JobManager struct {
-	jobs      map[string]*Job
-	mu        sync.RWMutex
+	jobs map[string]*Job
+	mu   sync.RWMutex
 	// EnqueueFn será suministrada externamente (por main) para encolar TaskAssignments en el scheduler.
 	EnqueueFn func(a *TaskAssignment)
+	// CancelFn (también de main) saca de la cola las tareas del job y avisa a
+	// los workers que estén corriendo alguna.
+	CancelFn func(jobID string)
+	// FinishedFn (también de main) se llama una vez por job cuando termina
+	// (SUCCEEDED, FAILED o CANCELLED), para liberar lo que quedó de él
+	// fuera del JobManager.
+	FinishedFn func(jobID string)
+	// wal, si el master guarda su estado en disco (ver Recover)
+	wal *WAL
+	// cache dice qué workers guardan las salidas de los stages con persist
+	cache cacheCatalog
 }
 
 func NewJobManager() *JobManager {
 	return &JobManager{
-		jobs: make(map[string]*Job),
+		jobs:  make(map[string]*Job),
+		cache: make(cacheCatalog),
 	}
 }
 
+// Add registra el job. Con WAL, vuelve cuando el alta ya está en disco:
+// un job aceptado no se pierde si el master cae.
 func (m *JobManager) Add(job *Job) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	if job.Tasks == nil {
 		job.Tasks = make(map[string]*JobTask)
 	}
 	m.jobs[job.ID] = job
+	seq := m.appendLocked(&walEntry{Job: jobHeader(job)})
+	wal := m.wal
+	m.mu.Unlock()
+
+	if wal != nil {
+		if err := wal.wait(seq); err != nil {
+			log.Printf("wal: job %s is not on disk: %v\n", job.ID, err)
+		}
+	}
 }
 
 func (m *JobManager) Get(id string) (*Job, bool) {
@@ -80,94 +144,227 @@ func (m *JobManager) AddTask(jobID string, t *JobTask) {
 	defer m.mu.Unlock()
 	if j, ok := m.jobs[jobID]; ok {
 		j.Tasks[t.ID] = t
+		j.touch(t)
+		m.persistLocked(j)
 	}
 }
 
 func (m *JobManager) UpdateTask(jobID, taskID string, update func(t *JobTask)) {
+	m.updateTask(jobID, taskID, nil, update)
+}
+
+// CompleteTask guarda el resultado de la tarea (update) y la marca DONE,
+// sólo si attemptID es uno de sus intentos vigentes (ver StartAttempt). El
+// resultado de un intento ya reemplazado (vencido, reintentado, perdido) o
+// que llega después de que otro intento completó la tarea se descarta y se
+// anota en el log; uno repetido del intento que la completó se ignora.
+// Devuelve false si el resultado se descartó.
+func (m *JobManager) CompleteTask(jobID, taskID, attemptID string, update func(t *JobTask)) bool {
+	authoritative := func(t *JobTask) bool {
+		switch {
+		case t.Status == "DONE":
+			if t.AttemptID != attemptID {
+				log.Printf("task %s: ignoring result of attempt %s, already completed by attempt %s\n", taskID, attemptID, t.AttemptID)
+			}
+			return false
+		case !t.live[attemptID]:
+			log.Printf("task %s: ignoring result of stale attempt %s\n", taskID, attemptID)
+			return false
+		}
+		return true
+	}
+	return m.updateTask(jobID, taskID, authoritative, func(t *JobTask) {
+		update(t)
+		t.Status = "DONE"
+		t.AttemptID = attemptID
+		t.live = nil
+	})
+}
+
+// StartAttempt registra attemptID como intento vigente de la tarea: desde
+// ahora puede completarla. Devuelve false si la tarea ya no hace falta (ver
+// TaskRunnable).
+func (m *JobManager) StartAttempt(jobID, taskID, attemptID string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if !m.runnableLocked(jobID, taskID) {
+		return false
+	}
+	t := m.jobs[jobID].Tasks[taskID]
+	if t.live == nil {
+		t.live = make(map[string]bool)
+	}
+	t.live[attemptID] = true
+	return true
+}
+
+// EndAttempt saca attemptID de los intentos vigentes de la tarea (falló, se
+// perdió o se canceló) y, si rec no es nil, lo agrega a su historial.
+func (m *JobManager) EndAttempt(jobID, taskID, attemptID string, rec *TaskAttempt) {
+	m.UpdateTask(jobID, taskID, func(t *JobTask) {
+		delete(t.live, attemptID)
+		if rec != nil {
+			t.History = append(t.History, *rec)
+		}
+	})
+}
+
+// updateTask aplica update a la tarea si accept (cuando no es nil) la
+// acepta, avanza el job si la tarea terminó y devuelve si la aplicó.
+func (m *JobManager) updateTask(jobID, taskID string, accept func(t *JobTask) bool, update func(t *JobTask)) bool {
+	m.mu.Lock()
 
 	j, ok := m.jobs[jobID]
 	if !ok {
-		return
+		m.mu.Unlock()
+		return false
 	}
 	task, ok := j.Tasks[taskID]
-	if !ok {
-		return
+	if !ok || j.State.Finished() {
+		// los resultados que llegan de un job ya terminado (p.ej. cancelado)
+		// se descartan
+		m.mu.Unlock()
+		return false
+	}
+	if accept != nil && !accept(task) {
+		m.mu.Unlock()
+		return false
 	}
 
+	prev := task.Status
 	update(task)
+	j.touch(task)
 
-	// recompute progress
-	total := len(j.Tasks)
-	if total == 0 {
-		return
+	// si la tarea acaba de terminar, avanzar el stage y lanzar los hijos listos
+	var next []*TaskAssignment
+	var commit *sinkCommit
+	if prev != "DONE" && task.Status == "DONE" {
+		task.Error = ""
+		next, commit = m.onTaskDoneLocked(j, task)
+	}
+
+	m.updateProgressLocked(j)
+	m.persistLocked(j)
+	failed := j.State == JobFailed
+	m.mu.Unlock()
+
+	m.afterUpdate(jobID, failed, next)
+	if commit != nil {
+		m.commitSink(commit)
+	}
+	return true
+}
+
+// afterUpdate hace, ya sin m.mu, lo que sigue a un cambio del job: cortar
+// el resto si falló y encolar las tareas que quedaron listas.
+func (m *JobManager) afterUpdate(jobID string, failed bool, next []*TaskAssignment) {
+	// un commit fallido con fail_fast detiene el resto del job
+	if failed && m.CancelFn != nil {
+		m.CancelFn(jobID)
 	}
-	done := 0
-	for _, tt := range j.Tasks {
-		if tt.Status == "DONE" {
-			done++
+
+	// encolar fuera del lock: EnqueueFn no debe depender del JobManager bloqueado
+	for _, a := range next {
+		if m.EnqueueFn != nil {
+			m.EnqueueFn(a)
 		}
 	}
-	j.Progress = float32(done) / float32(total)
+}
+
+// Cancel pasa el job a CANCELLED: sus tareas sin terminar y sus stages
+// pendientes o en curso quedan CANCELLED y no se lanzan más stages. Después
+// llama a CancelFn para sacar las tareas encoladas y cortar las que estén
+// corriendo. Devuelve el estado del job y false si no existe o ya había
+// terminado.
+func (m *JobManager) Cancel(jobID string) (JobState, bool) {
+	m.mu.Lock()
+	j, ok := m.jobs[jobID]
+	if !ok {
+		m.mu.Unlock()
+		return "", false
+	}
+	if j.State.Finished() {
+		state := j.State
+		m.mu.Unlock()
+		return state, false
+	}
+
+	stopJobLocked(j, JobCancelled)
+	m.persistLocked(j)
+	m.mu.Unlock()
+
+	log.Printf("job %s cancelled\n", jobID)
+	if m.CancelFn != nil {
+		m.CancelFn(jobID)
+	}
+	return JobCancelled, true
+}
+
+// finishedLocked avisa a FinishedFn, una sola vez y sin esperarlo, si el
+// job terminó. Lo llama persistLocked, por donde pasa cada cambio de un job.
+func (m *JobManager) finishedLocked(job *Job) {
+	if !job.State.Finished() || job.ended {
+		return
+	}
+	job.ended = true
+	if m.FinishedFn != nil {
+		go m.FinishedFn(job.ID)
+	}
+}
+
+// TaskRunnable indica si todavía hace falta ejecutar la tarea: el job sigue
+// en curso, su stage está corriendo y la tarea no terminó. El scheduler
+// descarta en vez de despachar o reintentar las que no lo están.
+func (m *JobManager) TaskRunnable(jobID, taskID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.runnableLocked(jobID, taskID)
+}
 
-	if done == total {
-		j.State = JobSuccess
+func (m *JobManager) runnableLocked(jobID, taskID string) bool {
+	j, ok := m.jobs[jobID]
+	if !ok || j.State.Finished() {
+		return false
+	}
+	t, ok := j.Tasks[taskID]
+	if !ok || t.Status == "DONE" {
+		return false
 	}
+	ss, ok := j.Stages[t.StageID]
+	return ok && ss.State == StageRunning
 }
 
 // BuildTasks crea TaskAssignment para las etapas fuente (sin dependencias)
-// y registra las JobTask en el JobManager. Devuelve la lista de assignments
-// para que el scheduler los encole (vía EnqueueFn).
+// y las que se leen de la caché (ver planCacheLocked), y registra las
+// JobTask en el JobManager. Devuelve la lista de assignments para que el
+// scheduler los encole (vía EnqueueFn). Los stages con dependencias se
+// lanzan después, cuando sus padres terminan (ver onTaskDoneLocked). Si el
+// job ya fue construido no devuelve nada.
 func (m *JobManager) BuildTasks(job *Job) []*TaskAssignment {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if job.State != JobAccepted {
+		return nil
+	}
+
+	m.initStagesLocked(job)
+	m.planCacheLocked(job)
+
 	var out []*TaskAssignment
 
 	for _, st := range job.DAG.Stages {
 		// fuente = sin dependencias
-		if len(st.Dependencies) != 0 {
+		ss := job.Stages[st.ID]
+		if ss.State != StagePending || (len(st.Dependencies) != 0 && !ss.FromCache) {
 			continue
 		}
-
-		parts := st.Partitions
-		if parts <= 0 {
-			parts = 1
-		}
-
-		for p := 0; p < parts; p++ {
-			tid := fmt.Sprintf("%s-%s-p%d", job.ID, st.ID, p)
-
-			// registrar tarea en JobManager
-			t := &JobTask{
-				ID:        tid,
-				StageID:   st.ID,
-				Partition: p,
-				Status:    "PENDING",
-				Attempts:  0,
-			}
-			if job.Tasks == nil {
-				job.Tasks = make(map[string]*JobTask)
-			}
-			job.Tasks[tid] = t
-
-			// crear assignment neutro (sin importar scheduler)
-			a := &TaskAssignment{
-				JobID:     job.ID,
-				TaskID:    tid,
-				StageID:   st.ID,
-				Partition: p,
-				Attempts:  0,
-				Op:        st.Op,
-				Params:    st.Params,
-			}
-			out = append(out, a)
-		}
+		out = append(out, m.launchStageLocked(job, st)...)
 	}
 
 	// marcar job corriendo
 	job.State = JobRunning
+	m.persistLocked(job)
 
 	return out
 }
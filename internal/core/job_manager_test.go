@@ -0,0 +1,137 @@
+package core
+
+import "testing"
+
+func TestCompleteTaskIgnoresStaleAttempts(t *testing.T) {
+	m := NewJobManager()
+	job := newTestJob("job-1", 2)
+	m.Add(job)
+	m.BuildTasks(job)
+	tid := taskID("job-1", "s1", 0)
+
+	complete := func(attemptID string) bool {
+		return m.CompleteTask("job-1", tid, attemptID, func(jt *JobTask) {
+			jt.Result = []interface{}{attemptID}
+		})
+	}
+
+	// a1 se perdió y se relanzó como a2; a3 es una copia especulativa
+	m.StartAttempt("job-1", tid, "a1")
+	m.StartAttempt("job-1", tid, "a2")
+	m.StartAttempt("job-1", tid, "a3")
+	m.EndAttempt("job-1", tid, "a1", nil)
+
+	if complete("a1") {
+		t.Errorf("result of the replaced attempt a1 was accepted")
+	}
+	if complete("a9") {
+		t.Errorf("result of an attempt that was never started was accepted")
+	}
+	if !complete("a2") {
+		t.Fatalf("result of the live attempt a2 was dropped")
+	}
+	if complete("a3") {
+		t.Errorf("result of a3 was accepted after a2 completed the task")
+	}
+	if complete("a2") {
+		t.Errorf("a repeated report of a2 was applied twice")
+	}
+
+	task := job.Tasks[tid]
+	if task.Status != "DONE" || task.AttemptID != "a2" || task.Result[0] != "a2" {
+		t.Errorf("task = %s by %s with %v, want DONE by a2 with its result", task.Status, task.AttemptID, task.Result)
+	}
+	if ss := job.Stages["s1"]; ss.Done != 1 {
+		t.Errorf("stage counted %d done tasks, want 1", ss.Done)
+	}
+}
+
+func TestCancelStopsUnfinishedTasks(t *testing.T) {
+	cases := []struct {
+		name      string
+		finish    []string // stages de newDAGJob que terminan antes de cancelar
+		state     JobState // estado del job antes de cancelar
+		cancelled bool
+		tasks     map[string]string // stage -> estado de sus tareas
+		stages    map[string]StageState
+	}{
+		{
+			name:      "before any task finished",
+			state:     JobRunning,
+			cancelled: true,
+			tasks:     map[string]string{"a": "CANCELLED"},
+			stages:    map[string]StageState{"a": StageCancelled, "b": StageCancelled},
+		},
+		{
+			name:      "keeps finished stages",
+			finish:    []string{"a"},
+			state:     JobRunning,
+			cancelled: true,
+			tasks:     map[string]string{"a": "DONE", "b": "CANCELLED"},
+			stages:    map[string]StageState{"a": StageDone, "b": StageCancelled},
+		},
+		{
+			name:   "job already finished",
+			finish: []string{"a", "b"},
+			state:  JobSuccess,
+			tasks:  map[string]string{"a": "DONE", "b": "DONE"},
+			stages: map[string]StageState{"a": StageDone, "b": StageDone},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewJobManager()
+			job := newDAGJob(map[string][]string{"a": nil, "b": {"a"}})
+			m.Add(job)
+			m.BuildTasks(job)
+			for _, s := range tc.finish {
+				finishStage(t, m, s)
+			}
+			var stopped []string
+			m.CancelFn = func(jobID string) { stopped = append(stopped, jobID) }
+
+			state, ok := m.Cancel("job-1")
+			if ok != tc.cancelled || (ok && state != JobCancelled) || (!ok && state != tc.state) {
+				t.Fatalf("Cancel = %s, %v; want cancelled %v from %s", state, ok, tc.cancelled, tc.state)
+			}
+			if tc.cancelled != (len(stopped) == 1) {
+				t.Errorf("CancelFn called for %v, want it called only when the job is cancelled", stopped)
+			}
+			for id, task := range job.Tasks {
+				if want := tc.tasks[task.StageID]; task.Status != want {
+					t.Errorf("task %s = %s, want %s", id, task.Status, want)
+				}
+			}
+			for id, want := range tc.stages {
+				if got := job.Stages[id].State; got != want {
+					t.Errorf("stage %s = %s, want %s", id, got, want)
+				}
+			}
+		})
+	}
+
+	m := NewJobManager()
+	if _, ok := m.Cancel("job-9"); ok {
+		t.Errorf("cancelled a job that does not exist")
+	}
+}
+
+func TestCancelledJobDropsLateResults(t *testing.T) {
+	m := NewJobManager()
+	job := newDAGJob(map[string][]string{"a": nil})
+	m.Add(job)
+	m.BuildTasks(job)
+	tid := taskID("job-1", "a", 0)
+	m.StartAttempt("job-1", tid, "a1")
+	m.Cancel("job-1")
+
+	if m.CompleteTask("job-1", tid, "a1", func(jt *JobTask) {}) {
+		t.Errorf("a result that arrived after the cancel completed the task")
+	}
+	if m.TaskRunnable("job-1", tid) {
+		t.Errorf("a task of a cancelled job is still runnable")
+	}
+	if job.State != JobCancelled || job.Tasks[tid].Status != "CANCELLED" {
+		t.Errorf("job = %s with task %s, want CANCELLED", job.State, job.Tasks[tid].Status)
+	}
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Varios masters pueden compartir el directorio de estado: uno es el líder
+// (escribe el WAL, corre el scheduler) y los demás esperan en standby. El
+// líder es quien tiene el lease de leader.json y lo renueva cada TTL/3; si
+// deja de renovarlo (se cayó o se colgó) un standby lo toma cuando vence.
+//
+// Cada lease tomado lleva un epoch mayor que el anterior. Un líder que se
+// colgó puede despertar con el lease ya en manos de otro: antes de cada
+// escritura del WAL (ver Fence) confirma que el lease sigue siendo suyo,
+// con su epoch, y que no venció; si no, deja de ser líder y no escribe.
+
+const leaderFile = "leader.json"
+
+// LeaderLease es el contenido de leader.json.
+type LeaderLease struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Epoch   int64     `json:"epoch"`
+	Expires time.Time `json:"expires"`
+}
+
+// Elector disputa el lease de líder en un directorio de estado.
+type Elector struct {
+	path string
+	id   string
+	url  string
+	TTL  time.Duration
+
+	mu     sync.Mutex
+	leader bool
+	ready  bool        // ya recuperó el estado y atiende
+	lease  LeaderLease // el último leído o escrito
+	held   LeaderLease // el último que escribió este master
+	lost   func()
+	done   chan struct{} // se cierra cuando el master deja de ser líder
+}
+
+// NewElector arma un elector para el master id, que atiende en url.
+func NewElector(dir, id, url string) (*Elector, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Elector{
+		path: filepath.Join(dir, leaderFile),
+		id:   id,
+		url:  url,
+		TTL:  10 * time.Second,
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Leader indica si este master es el líder y ya atiende y, si no, la URL
+// del líder según el último lease leído ("" si no se conoce o si es este
+// master, que todavía está recuperando el estado).
+func (e *Elector) Leader() (bool, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader {
+		return e.ready, ""
+	}
+	if time.Now().After(e.lease.Expires) {
+		return false, ""
+	}
+	return false, e.lease.URL
+}
+
+// Run disputa el lease hasta ganarlo, llama a elected y después lo renueva.
+// Mientras elected no vuelve (el líder recupera el estado) el master todavía
+// no atiende. En cuanto una renovación falla o aparece otro master con el
+// lease (este dejó de renovarlo a tiempo), deja de ser líder, llama a lost y
+// vuelve: el proceso ya no puede seguir escribiendo el estado.
+func (e *Elector) Run(elected, lost func()) {
+	e.mu.Lock()
+	e.lost = lost
+	e.mu.Unlock()
+
+	tick := time.NewTicker(e.TTL / 3)
+	defer tick.Stop()
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-tick.C:
+			case <-e.done:
+				return
+			}
+		}
+		cur, err := e.read()
+
+		e.mu.Lock()
+		leader := e.leader
+		if err == nil {
+			e.lease = cur
+		}
+		e.mu.Unlock()
+
+		if leader {
+			switch {
+			case err != nil:
+				e.stepDown(fmt.Sprintf("cannot read %s: %v", e.path, err))
+			case cur.ID != e.id || cur.Epoch != e.held.Epoch:
+				e.stepDown(fmt.Sprintf("lease taken over by %s (%s)", cur.ID, cur.URL))
+			default:
+				if err := e.write(cur.Epoch); err != nil {
+					e.stepDown(fmt.Sprintf("cannot renew: %v", err))
+				}
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("leader lease: cannot read %s: %v\n", e.path, err)
+			continue
+		}
+
+		// el lease es de este mismo master (se reinició) o ya venció
+		if cur.ID != e.id && time.Now().Before(cur.Expires) {
+			continue
+		}
+		if !e.acquire(cur.Epoch + 1) {
+			continue
+		}
+		log.Printf("master %s elected leader (epoch %d)\n", e.id, cur.Epoch+1)
+		e.setLeader(true)
+		go func() {
+			elected()
+			e.mu.Lock()
+			e.ready = true
+			e.mu.Unlock()
+		}()
+	}
+}
+
+// acquire escribe el lease a nombre de este master con epoch y, tras un
+// momento, confirma que ningún otro standby lo escribió encima.
+func (e *Elector) acquire(epoch int64) bool {
+	if err := e.write(epoch); err != nil {
+		log.Printf("leader lease: cannot write: %v\n", err)
+		return false
+	}
+	time.Sleep(e.TTL / 10)
+	cur, err := e.read()
+	return err == nil && cur.ID == e.id && cur.Epoch == epoch
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	e.leader = leader
+	e.mu.Unlock()
+}
+
+// Fence devuelve un error si este master ya no puede escribir el estado:
+// no es el líder, su lease venció sin renovarse o leader.json tiene otro
+// dueño u otro epoch. En los dos últimos casos deja de ser líder (ver
+// stepDown). El WAL la llama antes de cada escritura.
+func (e *Elector) Fence() error {
+	e.mu.Lock()
+	leader, held := e.leader, e.held
+	e.mu.Unlock()
+	if !leader {
+		return fmt.Errorf("not the leader")
+	}
+	if time.Now().After(held.Expires) {
+		e.stepDown("lease expired before it was renewed")
+		return fmt.Errorf("leader lease expired")
+	}
+	cur, err := e.read()
+	if err != nil {
+		return fmt.Errorf("cannot check leader lease: %v", err)
+	}
+	if cur.ID != e.id || cur.Epoch != held.Epoch {
+		e.stepDown(fmt.Sprintf("lease taken over by %s (%s)", cur.ID, cur.URL))
+		return fmt.Errorf("leader lease is held by %s (epoch %d)", cur.ID, cur.Epoch)
+	}
+	return nil
+}
+
+// stepDown deja de ser líder y avisa a lost (una sola vez): el proceso no
+// debe atender ni escribir más.
+func (e *Elector) stepDown(why string) {
+	e.mu.Lock()
+	was, lost := e.leader, e.lost
+	e.leader, e.ready = false, false
+	if was {
+		close(e.done)
+	}
+	e.mu.Unlock()
+	if !was {
+		return
+	}
+	log.Printf("leader lease: stepping down: %s\n", why)
+	if lost != nil {
+		lost()
+	}
+}
+
+// read lee el lease; si no existe devuelve uno vencido.
+func (e *Elector) read() (LeaderLease, error) {
+	var l LeaderLease
+	b, err := os.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	return l, json.Unmarshal(b, &l)
+}
+
+// write reemplaza el lease por uno de este master con epoch que vence en
+// TTL. El archivo se cambia con un rename para que nadie lea uno a medio
+// escribir.
+func (e *Elector) write(epoch int64) error {
+	l := LeaderLease{ID: e.id, URL: e.url, Epoch: epoch, Expires: time.Now().Add(e.TTL)}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp := e.path + "." + e.id + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.lease, e.held = l, l
+	e.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStandbyTakesOverExpiredLease(t *testing.T) {
+	dir := t.TempDir()
+	old, err := NewElector(dir, "m1", "http://m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.TTL = 300 * time.Millisecond
+	if !old.acquire(1) {
+		t.Fatal("first master did not get the lease")
+	}
+	// m1 deja de renovar: se colgó
+	expires := time.Now().Add(old.TTL)
+
+	standby, _ := NewElector(dir, "m2", "http://m2")
+	standby.TTL = old.TTL
+	elected := make(chan time.Time, 1)
+	go standby.Run(func() { elected <- time.Now() }, func() {})
+
+	select {
+	case at := <-elected:
+		if at.Before(expires) {
+			t.Errorf("standby took the lease %s before it expired", expires.Sub(at))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("standby did not take over the expired lease")
+	}
+	if cur, _ := old.read(); cur.ID != "m2" || cur.Epoch != 2 {
+		t.Errorf("lease = %s epoch %d, want m2 epoch 2", cur.ID, cur.Epoch)
+	}
+}
+
+func TestFenceStopsOldLeader(t *testing.T) {
+	dir := t.TempDir()
+	old, _ := NewElector(dir, "m1", "http://m1")
+	old.TTL = 2 * time.Second
+	lost := make(chan bool, 1)
+	go old.Run(func() {}, func() { lost <- true })
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if leader, _ := old.Leader(); leader {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first master was not elected")
+		}
+	}
+	if err := old.Fence(); err != nil {
+		t.Fatalf("leader is fenced: %v", err)
+	}
+
+	// m1 se colgó y m2 tomó el lease
+	standby, _ := NewElector(dir, "m2", "http://m2")
+	standby.TTL = 2 * time.Second
+	if !standby.acquire(2) {
+		t.Fatal("standby did not get the lease")
+	}
+	if err := old.Fence(); err == nil {
+		t.Errorf("old leader can still write after the takeover")
+	}
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Errorf("old leader did not step down")
+	}
+	if leader, _ := old.Leader(); leader {
+		t.Errorf("old leader still reports itself as leader")
+	}
+}
+
+func TestOldLeaderCannotTruncateNewLog(t *testing.T) {
+	dir := t.TempDir()
+	m1, old, _ := openState(t, dir, nil)
+	m1.Add(newTestJob("job-1", 1))
+
+	// m2 toma el estado y sigue en un log nuevo
+	m2, w2, _ := openState(t, dir, nil)
+	defer w2.Close()
+	job := newTestJob("job-2", 1)
+	m2.Add(job)
+
+	// el viejo líder, sin fencing, intenta un snapshot: no pisa el log de m2
+	m1.mu.Lock()
+	jobs, _ := m1.snapshotLocked()
+	m1.mu.Unlock()
+	if err := old.wait(old.snapshot(jobs)); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	m3, w3, _ := openState(t, dir, nil)
+	defer w3.Close()
+	if _, ok := m3.Get("job-2"); !ok {
+		t.Errorf("the new leader's log was overwritten by the old one")
+	}
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"log"
+	"sort"
+)
+
+// WorkerLost reacciona a un worker caído. Las tareas que corrían ahí las
+// reencola el scheduler; acá se recupera por linaje lo que ya había
+// terminado: las tareas DONE en ese worker cuyo shuffle todavía lo necesita
+// algún hijo ancho vuelven a PENDING y se recalculan. Si un stage ancho que
+// estaba corriendo pierde parte de su input, vuelve a PENDING y se relanza
+// cuando sus dependencias terminen de nuevo. Un stage que se leía de la
+// caché y tenía en el worker particiones que todavía no leyó se recalcula
+// por linaje (ver replanLocked).
+//
+// Devuelve los assignments a encolar y las tareas cuyo intento en cola o en
+// curso quedó inválido (leía un shuffle perdido) y hay que descartar.
+// Los resultados que ya están en el master (Result) no se pierden.
+func (m *JobManager) WorkerLost(workerID string) ([]*TaskAssignment, []string) {
+	m.mu.Lock()
+	m.cache.dropWorker(workerID)
+	m.mu.Unlock()
+	return m.outputsLost(workerID, func(*JobTask) bool { return true })
+}
+
+// OutputsMissing compara las salidas de shuffle que dice tener un worker al
+// volver a registrarse (held, IDs de las tareas que las escribieron) con
+// las tareas DONE que el master tiene en él: las que el worker ya no tiene
+// (p.ej. reinició y perdió su SHUFFLE_DIR) se recalculan como en
+// WorkerLost, en vez de esperar a que falle el fetch de sus hijos.
+func (m *JobManager) OutputsMissing(workerID string, held []string) ([]*TaskAssignment, []string) {
+	has := make(map[string]bool, len(held))
+	for _, id := range held {
+		has[id] = true
+	}
+	return m.outputsLost(workerID, func(t *JobTask) bool { return !has[t.ID] })
+}
+
+// outputsLost recupera por linaje las salidas de shuffle de workerID que
+// cumplen lost (ver WorkerLost).
+func (m *JobManager) outputsLost(workerID string, lost func(t *JobTask) bool) ([]*TaskAssignment, []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []*TaskAssignment
+	var voided []string
+	for _, job := range m.jobs {
+		if job.State != JobRunning {
+			continue
+		}
+
+		// las tareas perdidas pueden dejar sin terminar a un stage cuyos
+		// padres también tenían salida en el worker: repetir hasta que no
+		// cambie nada
+		reset := map[string]map[int]bool{}
+		for changed := true; changed; {
+			changed = false
+			for _, t := range job.Tasks {
+				if t.Status != "DONE" || t.AssignedTo != workerID || !shuffleNeeded(job, t.StageID) || !lost(t) {
+					continue
+				}
+				t.Status = "PENDING"
+				t.OutputHost = ""
+				job.touch(t)
+				ss := job.Stages[t.StageID]
+				ss.Done--
+				if ss.State == StageDone {
+					ss.State = StageRunning
+				}
+				if reset[t.StageID] == nil {
+					reset[t.StageID] = map[int]bool{}
+				}
+				reset[t.StageID][t.Partition] = true
+				changed = true
+			}
+		}
+		// un stage que se lee de la caché y perdió particiones que todavía
+		// no leyó se recalcula por linaje
+		uncached := m.uncachedLocked(job)
+		for _, id := range uncached {
+			more, v := m.replanLocked(job, id)
+			out = append(out, more...)
+			voided = append(voided, v...)
+			delete(reset, id)
+		}
+		if len(reset) == 0 && len(uncached) == 0 {
+			continue
+		}
+
+		// un stage ancho en curso necesita todo el shuffle de sus padres
+		for id, st := range job.DAG.Stages {
+			ss := job.Stages[id]
+			if ss.State != StageRunning || !st.IsWide() || ss.FromCache || m.depsDoneLocked(job, st) {
+				continue
+			}
+			ss.State = StagePending
+			for p := 0; p < ss.Partitions; p++ {
+				if t, ok := job.Tasks[taskID(job.ID, id, p)]; ok && t.Status != "DONE" {
+					t.Status = "PENDING"
+					t.live = nil
+					job.touch(t)
+					voided = append(voided, t.ID)
+				}
+			}
+			delete(reset, id)
+		}
+
+		ids := make([]string, 0, len(reset))
+		for id := range reset {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			log.Printf("job %s: recomputing %d partitions of stage %s lost on worker %s\n",
+				job.ID, len(reset[id]), id, workerID)
+			out = append(out, m.launchTasksLocked(job, job.DAG.Stages[id], reset[id])...)
+		}
+		m.updateProgressLocked(job)
+		m.persistLocked(job)
+	}
+	return out, voided
+}
+
+// shuffleNeeded indica si algún hijo ancho de stageID todavía no terminó de
+// leer su shuffle.
+func shuffleNeeded(job *Job, stageID string) bool {
+	for _, child := range job.DAG.Stages {
+		if !child.IsWide() || !dependsOn(child, stageID) || job.Stages[child.ID].FromCache {
+			continue
+		}
+		if s := job.Stages[child.ID].State; s == StagePending || s == StageRunning {
+			return true
+		}
+	}
+	return false
+}
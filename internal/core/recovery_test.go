@@ -0,0 +1,90 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"batchdag/internal/dag"
+)
+
+// completeOn completa la tarea como si la hubiera corrido workerID, que
+// guarda su salida.
+func completeOn(t *testing.T, m *JobManager, tid, workerID string) []*TaskAssignment {
+	t.Helper()
+	var enqueued []*TaskAssignment
+	m.EnqueueFn = func(a *TaskAssignment) { enqueued = append(enqueued, a) }
+	defer func() { m.EnqueueFn = nil }()
+	m.StartAttempt("job-1", tid, "a-"+workerID)
+	ok := m.CompleteTask("job-1", tid, "a-"+workerID, func(jt *JobTask) {
+		jt.AssignedTo, jt.OutputHost = workerID, "http://"+workerID
+		jt.Result = []interface{}{tid}
+	})
+	if !ok {
+		t.Fatalf("task %s was not completed", tid)
+	}
+	return enqueued
+}
+
+func TestWorkerLostRecomputesShuffleOutputs(t *testing.T) {
+	cases := []struct {
+		name     string
+		childOp  string
+		done     int    // particiones del hijo terminadas antes de perder el worker
+		lost     string // worker que se pierde
+		launched []string
+		voided   []string
+	}{
+		{"wide child running", "reduce_by_key", 0, "w1", []string{"m-p0"}, []string{"r-p0", "r-p1"}},
+		{"wide child partly done", "reduce_by_key", 1, "w1", []string{"m-p0"}, []string{"r-p1"}},
+		{"narrow child", "map", 0, "w1", nil, nil},
+		{"worker without outputs", "reduce_by_key", 0, "w3", nil, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := dag.New()
+			d.AddStage(&dag.Stage{ID: "m", Op: "map", Params: map[string]interface{}{"fn": "to_lower"}, Partitions: 2})
+			d.AddStage(&dag.Stage{ID: "r", Op: tc.childOp, Params: map[string]interface{}{"key": "k", "fn": "to_lower"}, Partitions: 2, Dependencies: []string{"m"}})
+			job := &Job{ID: "job-1", DAG: d, State: JobAccepted, CreatedAt: time.Now(), Priority: 1}
+			m := NewJobManager()
+			m.Add(job)
+			m.BuildTasks(job)
+			completeOn(t, m, "job-1-m-p0", "w1")
+			completeOn(t, m, "job-1-m-p1", "w2")
+			for p := 0; p < tc.done; p++ {
+				completeOn(t, m, taskID("job-1", "r", p), "w2")
+			}
+
+			next, voided := m.WorkerLost(tc.lost)
+			var got []string
+			for _, a := range next {
+				got = append(got, a.TaskID[len("job-1-"):])
+			}
+			for i := range voided {
+				voided[i] = voided[i][len("job-1-"):]
+			}
+			sort.Strings(voided)
+			if !reflect.DeepEqual(got, tc.launched) || !reflect.DeepEqual(voided, tc.voided) {
+				t.Fatalf("WorkerLost launched %v and voided %v, want %v and %v", got, voided, tc.launched, tc.voided)
+			}
+			if len(tc.launched) == 0 {
+				return
+			}
+
+			// el hijo vuelve a esperar a su padre y relee el shuffle recalculado
+			if s := job.Stages["r"]; s.State != StagePending || s.Done != tc.done {
+				t.Errorf("r = %s with %d done, want PENDING keeping %d done", s.State, s.Done, tc.done)
+			}
+			relaunched := completeOn(t, m, "job-1-m-p0", "w3")
+			if len(relaunched) != 2-tc.done {
+				t.Fatalf("recomputing m relaunched %d tasks of r, want %d", len(relaunched), 2-tc.done)
+			}
+			for _, a := range relaunched {
+				if src := a.ShuffleRead.Sources[0]; src.Host != "http://w3" {
+					t.Errorf("%s reads the recomputed output from %s, want w3", a.TaskID, src.Host)
+				}
+			}
+		})
+	}
+}
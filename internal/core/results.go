@@ -0,0 +1,91 @@
+package core
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrJobNotFound   = errors.New("job not found")
+	ErrStageNotFound = errors.New("stage not found")
+	ErrBadCursor     = errors.New("invalid cursor")
+)
+
+// ResultCursor apunta al próximo registro a devolver: stage (índice dentro
+// de los stages consultados), partición y offset dentro de la partición.
+type ResultCursor struct {
+	Stage     int
+	Partition int
+	Offset    int
+}
+
+// Encode serializa el cursor como un token opaco para la API.
+func (c ResultCursor) Encode() string {
+	raw := fmt.Sprintf("%d.%d.%d", c.Stage, c.Partition, c.Offset)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor interpreta un token de Encode; "" es el inicio.
+func DecodeCursor(token string) (ResultCursor, error) {
+	var c ResultCursor
+	if token == "" {
+		return c, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, ErrBadCursor
+	}
+	if _, err := fmt.Sscanf(string(raw), "%d.%d.%d", &c.Stage, &c.Partition, &c.Offset); err != nil {
+		return c, ErrBadCursor
+	}
+	if c.Stage < 0 || c.Partition < 0 || c.Offset < 0 {
+		return c, ErrBadCursor
+	}
+	return c, nil
+}
+
+// Results devuelve hasta limit registros de resultado de un job a partir del
+// cursor, y el cursor siguiente (nil si no hay más). Sin stageID recorre los
+// stages hoja del DAG; con stageID sólo ese stage. Sólo cuentan las tareas
+// DONE; los stages cuya salida no vuelve al master (leída vía shuffle) no
+// tienen registros.
+func (m *JobManager) Results(jobID, stageID string, cur ResultCursor, limit int) ([]interface{}, *ResultCursor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	j, ok := m.jobs[jobID]
+	if !ok {
+		return nil, nil, ErrJobNotFound
+	}
+
+	stages := j.DAG.Leaves()
+	if stageID != "" {
+		if _, ok := j.DAG.Stages[stageID]; !ok {
+			return nil, nil, ErrStageNotFound
+		}
+		stages = []string{stageID}
+	}
+
+	out := []interface{}{}
+	for ; cur.Stage < len(stages); cur.Stage, cur.Partition, cur.Offset = cur.Stage+1, 0, 0 {
+		ss, ok := j.Stages[stages[cur.Stage]]
+		if !ok {
+			continue
+		}
+		for ; cur.Partition < ss.Partitions; cur.Partition, cur.Offset = cur.Partition+1, 0 {
+			t, ok := j.Tasks[taskID(j.ID, ss.ID, cur.Partition)]
+			if !ok || t.Status != "DONE" {
+				continue
+			}
+			for ; cur.Offset < len(t.Result); cur.Offset++ {
+				if len(out) == limit {
+					next := cur
+					return out, &next, nil
+				}
+				out = append(out, t.Result[cur.Offset])
+			}
+		}
+	}
+	return out, nil, nil
+}
@@ -0,0 +1,423 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"batchdag/internal/dag"
+)
+
+type StageState string
+
+const (
+	StagePending   StageState = "PENDING"
+	StageRunning   StageState = "RUNNING"
+	StageDone      StageState = "DONE"
+	StageFailed    StageState = "FAILED"
+	StageCancelled StageState = "CANCELLED"
+	// SKIPPED: no se ejecuta porque depende de un stage fallido
+	StageSkipped StageState = "SKIPPED"
+	// CACHED: no se ejecuta porque los stages que lo necesitaban leen su
+	// salida de la caché (ver cache.go)
+	StageCached StageState = "CACHED"
+)
+
+// StageStatus lleva el avance de un stage dentro de un job: cuántas
+// particiones tiene y cuántas ya terminaron. CacheKey es la clave con que
+// se guarda su salida si tiene persist, y FromCache indica que la lee de la
+// caché en vez de calcularla.
+type StageStatus struct {
+	ID         string     `json:"id"`
+	State      StageState `json:"state"`
+	Partitions int        `json:"partitions"`
+	Done       int        `json:"done"`
+	Error      string     `json:"error,omitempty"`
+	CacheKey   string     `json:"cache_key,omitempty"`
+	FromCache  bool       `json:"from_cache,omitempty"`
+}
+
+// initStagesLocked registra todos los stages del DAG como PENDING.
+// Debe llamarse con m.mu tomado.
+func (m *JobManager) initStagesLocked(job *Job) {
+	if job.Stages == nil {
+		job.Stages = make(map[string]*StageStatus)
+	}
+	for id := range job.DAG.Stages {
+		if _, ok := job.Stages[id]; !ok {
+			job.Stages[id] = &StageStatus{ID: id, State: StagePending}
+		}
+	}
+}
+
+// launchStageLocked crea las JobTask y los TaskAssignment de un stage,
+// conectando como input las salidas de sus dependencias.
+// Debe llamarse con m.mu tomado.
+func (m *JobManager) launchStageLocked(job *Job, st *dag.Stage) []*TaskAssignment {
+	return m.launchTasksLocked(job, st, nil)
+}
+
+// launchTasksLocked lanza las particiones de st que no estén DONE; si only
+// no es nil, sólo las que estén en only. Las tareas que ya existían
+// conservan sus intentos. Un stage que se lee de la caché no recibe input:
+// cada tarea toma su partición guardada. Debe llamarse con m.mu tomado.
+func (m *JobManager) launchTasksLocked(job *Job, st *dag.Stage, only map[int]bool) []*TaskAssignment {
+	parts := job.DAG.NumPartitions(st)
+	ss := job.Stages[st.ID]
+
+	var inputs [][]interface{}
+	var rangeSpec *dag.PartitionerSpec
+	var splits [][]dag.InputSplit
+	switch {
+	case ss.FromCache:
+	case st.IsWide():
+		rangeSpec = m.sampledPartitionerLocked(job, st, parts)
+	default:
+		inputs = m.stageInputsLocked(job, st, parts)
+		splits = planStageSplits(st, parts)
+	}
+	writes, discard := shuffleWrites(job, st)
+	var cacheWrite *dag.CacheWrite
+	if ss.CacheKey != "" && !ss.FromCache {
+		cacheWrite = &dag.CacheWrite{Key: ss.CacheKey, Level: st.Persist}
+	}
+
+	if job.Tasks == nil {
+		job.Tasks = make(map[string]*JobTask)
+	}
+
+	var out []*TaskAssignment
+	done := 0
+	for p := 0; p < parts; p++ {
+		tid := taskID(job.ID, st.ID, p)
+
+		// registrar tarea en JobManager
+		t, ok := job.Tasks[tid]
+		if ok && t.Status == "DONE" {
+			done++
+			continue
+		}
+		if ok && only != nil && !only[p] {
+			continue
+		}
+		if !ok {
+			t = &JobTask{
+				ID:        tid,
+				StageID:   st.ID,
+				Partition: p,
+			}
+			job.Tasks[tid] = t
+		}
+		t.Status = "PENDING"
+		job.touch(t)
+
+		// crear assignment neutro (sin importar scheduler)
+		a := &TaskAssignment{
+			JobID:         job.ID,
+			TaskID:        tid,
+			StageID:       st.ID,
+			Partition:     p,
+			Attempts:      t.Attempts,
+			Op:            st.Op,
+			Params:        st.Params,
+			ShuffleWrites: writes,
+			DiscardOutput: discard,
+			Timeout:       st.TaskTimeout(),
+			MemoryMB:      st.TaskMemoryMB(),
+			CPUs:          st.TaskCPUs(),
+			Pool:          job.Pool,
+			Priority:      job.Priority,
+			CacheWrite:    cacheWrite,
+		}
+		if inputs != nil {
+			a.Input = inputs[p]
+		}
+		if splits != nil {
+			a.Splits = splits[p]
+		}
+		switch {
+		case ss.FromCache:
+			a.CacheRead, a.Preferred = m.cacheReadLocked(ss, p)
+		case st.IsWide():
+			a.ShuffleRead = m.shuffleReadLocked(job, st, p, parts)
+			a.ShuffleRead.Partitioner = rangeSpec
+			a.Preferred = preferredWorkers(job, a.ShuffleRead)
+		}
+		out = append(out, a)
+	}
+
+	ss.State = StageRunning
+	ss.Partitions = parts
+	ss.Done = done
+
+	return out
+}
+
+// planStageSplits divide los archivos de un stage fuente en rangos de bytes,
+// uno por partición, para que cada worker lea sólo su parte. Si el master no
+// ve los archivos devuelve nil y los workers leen por su cuenta.
+func planStageSplits(st *dag.Stage, parts int) [][]dag.InputSplit {
+	if !dag.IsSourceOp(st.Op) {
+		return nil
+	}
+	path, _ := st.Params["path"].(string)
+	if path == "" {
+		return nil
+	}
+	compression, _ := st.Params["compression"].(string)
+	splits, err := dag.PlanSplits(path, parts, compression)
+	if err != nil {
+		log.Printf("stage %s: cannot plan input splits for %s: %v\n", st.ID, path, err)
+		return nil
+	}
+	if splits == nil {
+		log.Printf("stage %s: no input files visible on master for %s, workers will read them\n", st.ID, path)
+	}
+	return splits
+}
+
+// stageInputsLocked reparte entre las particiones de un stage angosto los
+// registros de sus dependencias: la partición q de cada dependencia alimenta
+// la partición q % parts del hijo. Un stage fuente no tiene input.
+func (m *JobManager) stageInputsLocked(job *Job, st *dag.Stage, parts int) [][]interface{} {
+	if len(st.Dependencies) == 0 {
+		return nil
+	}
+
+	inputs := make([][]interface{}, parts)
+	for _, dep := range st.Dependencies {
+		for q := 0; q < job.Stages[dep].Partitions; q++ {
+			pt, ok := job.Tasks[taskID(job.ID, dep, q)]
+			if !ok {
+				continue
+			}
+			inputs[q%parts] = append(inputs[q%parts], pt.Result...)
+		}
+	}
+	return inputs
+}
+
+// shuffleWrites arma la lista de shuffles que deben escribir las tareas de
+// st, uno por cada hijo ancho. discard indica que todos los hijos leen vía
+// shuffle, así que la salida no necesita viajar al master. Los hijos que se
+// leen de la caché no leen nada de st.
+func shuffleWrites(job *Job, st *dag.Stage) (writes []dag.ShuffleWrite, discard bool) {
+	children := 0
+	for _, child := range job.DAG.Stages {
+		if !dependsOn(child, st.ID) || job.Stages[child.ID].FromCache {
+			continue
+		}
+		children++
+		if !child.IsWide() {
+			continue
+		}
+		writes = append(writes, dag.ShuffleWrite{
+			ShuffleID:   shuffleID(job.ID, child.ID),
+			Buckets:     job.DAG.NumPartitions(child),
+			Partitioner: child.ShufflePartitioner(),
+		})
+	}
+	return writes, children > 0 && len(writes) == children
+}
+
+// shuffleReadLocked indica a la partición p de un stage ancho de qué
+// workers traer su bucket: uno por cada tarea de sus dependencias.
+func (m *JobManager) shuffleReadLocked(job *Job, st *dag.Stage, p, parts int) *dag.ShuffleRead {
+	rd := &dag.ShuffleRead{
+		ShuffleID: shuffleID(job.ID, st.ID),
+		Bucket:    p,
+		Buckets:   parts,
+	}
+	for _, dep := range st.Dependencies {
+		for q := 0; q < job.Stages[dep].Partitions; q++ {
+			pt, ok := job.Tasks[taskID(job.ID, dep, q)]
+			if !ok {
+				continue
+			}
+			rd.Sources = append(rd.Sources, dag.ShuffleSource{
+				Host:   pt.OutputHost,
+				TaskID: pt.ID,
+			})
+		}
+	}
+	return rd
+}
+
+// preferredWorkers ordena los workers que guardan salidas de shuffle que
+// lee rd, de los que tienen más a los que tienen menos, para que el
+// scheduler intente correr la tarea donde ya está la mayor parte del input.
+func preferredWorkers(job *Job, rd *dag.ShuffleRead) []string {
+	count := map[string]int{}
+	for _, src := range rd.Sources {
+		if pt, ok := job.Tasks[src.TaskID]; ok && pt.AssignedTo != "" {
+			count[pt.AssignedTo]++
+		}
+	}
+	out := make([]string, 0, len(count))
+	for id := range count {
+		out = append(out, id)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if count[out[i]] != count[out[j]] {
+			return count[out[i]] > count[out[j]]
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// sampledPartitionerLocked calcula las boundaries de un partitioner range
+// que no las declara, a partir de las muestras de claves que devolvieron las
+// tareas map-side. Devuelve nil si el stage no necesita muestra.
+func (m *JobManager) sampledPartitionerLocked(job *Job, st *dag.Stage, parts int) *dag.PartitionerSpec {
+	spec := st.ShufflePartitioner()
+	if !spec.NeedsSample() {
+		return nil
+	}
+
+	sid := shuffleID(job.ID, st.ID)
+	var sample []interface{}
+	for _, dep := range st.Dependencies {
+		for q := 0; q < job.Stages[dep].Partitions; q++ {
+			if pt, ok := job.Tasks[taskID(job.ID, dep, q)]; ok {
+				sample = append(sample, pt.KeySamples[sid]...)
+			}
+		}
+	}
+
+	resolved := *spec
+	resolved.Boundaries = dag.RangeBoundaries(sample, parts)
+	return &resolved
+}
+
+// onTaskDoneLocked actualiza el stage de la tarea terminada y, si el stage
+// quedó completo, lanza los stages hijos cuyas dependencias ya terminaron.
+// Si es un sink, en vez de eso devuelve el commit de su salida, que el
+// llamador corre sin m.mu (ver commitSink). Debe llamarse con m.mu tomado.
+func (m *JobManager) onTaskDoneLocked(job *Job, t *JobTask) ([]*TaskAssignment, *sinkCommit) {
+	ss, ok := job.Stages[t.StageID]
+	if !ok || ss.State != StageRunning {
+		return nil, nil
+	}
+	ss.Done++
+	if t.Persisted {
+		m.cache.add(ss.CacheKey, t.Partition, t.AssignedTo, t.OutputHost)
+	}
+	if ss.Done < ss.Partitions {
+		return nil, nil
+	}
+
+	// un sink sólo publica su salida cuando todas sus particiones terminaron
+	if st := job.DAG.Stages[t.StageID]; dag.IsSinkOp(st.Op) {
+		c, err := m.sinkCommitLocked(job, st)
+		if err != nil {
+			m.failStageLocked(job, st.ID, "commit failed: "+err.Error())
+			return nil, nil
+		}
+		return nil, c
+	}
+	return m.stageDoneLocked(job, t.StageID), nil
+}
+
+// stageDoneLocked marca el stage DONE y lanza los hijos cuyas dependencias
+// ya terminaron. Debe llamarse con m.mu tomado.
+func (m *JobManager) stageDoneLocked(job *Job, stageID string) []*TaskAssignment {
+	job.Stages[stageID].State = StageDone
+
+	var out []*TaskAssignment
+	for _, child := range job.DAG.Stages {
+		if job.Stages[child.ID].State != StagePending || !dependsOn(child, stageID) {
+			continue
+		}
+		if !m.depsDoneLocked(job, child) {
+			continue
+		}
+		out = append(out, m.launchStageLocked(job, child)...)
+	}
+	return out
+}
+
+// StageProgress devuelve cuántas particiones del stage terminaron y cuántas
+// tiene; false si el job o el stage no existen.
+func (m *JobManager) StageProgress(jobID, stageID string) (done, parts int, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	j, ok := m.jobs[jobID]
+	if !ok {
+		return 0, 0, false
+	}
+	ss, ok := j.Stages[stageID]
+	if !ok {
+		return 0, 0, false
+	}
+	return ss.Done, ss.Partitions, true
+}
+
+func (m *JobManager) depsDoneLocked(job *Job, st *dag.Stage) bool {
+	for _, dep := range st.Dependencies {
+		if job.Stages[dep].State != StageDone {
+			return false
+		}
+	}
+	return true
+}
+
+// updateProgressLocked recalcula el progreso como el promedio del avance de
+// cada stage y marca el job como SUCCEEDED cuando todos terminaron, o FAILED
+// si ya no queda nada por correr y algún stage falló.
+func (m *JobManager) updateProgressLocked(job *Job) {
+	total := len(job.Stages)
+	if total == 0 {
+		return
+	}
+	var sum float32
+	done, ended, failed := 0, 0, 0
+	for _, ss := range job.Stages {
+		switch ss.State {
+		case StageCached:
+			// no corre: cuenta como terminado
+			done++
+			ended++
+			sum++
+			continue
+		case StageDone:
+			done++
+			ended++
+		case StageFailed:
+			failed++
+			ended++
+		case StageSkipped, StageCancelled:
+			ended++
+		}
+		if ss.Partitions > 0 {
+			sum += float32(ss.Done) / float32(ss.Partitions)
+		}
+	}
+	job.Progress = sum / float32(total)
+
+	if job.State.Finished() {
+		return
+	}
+	if done == total {
+		job.State = JobSuccess
+	} else if ended == total && failed > 0 {
+		job.State = JobFailed
+	}
+}
+
+func taskID(jobID, stageID string, p int) string {
+	return fmt.Sprintf("%s-%s-p%d", jobID, stageID, p)
+}
+
+func shuffleID(jobID, stageID string) string {
+	return jobID + "-" + stageID
+}
+
+func dependsOn(st *dag.Stage, id string) bool {
+	for _, dep := range st.Dependencies {
+		if dep == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"batchdag/internal/dag"
+)
+
+// newDAGJob arma un job con un stage map de dos particiones por cada
+// entrada de deps (stage -> dependencias).
+func newDAGJob(deps map[string][]string) *Job {
+	d := dag.New()
+	for id, ds := range deps {
+		d.AddStage(&dag.Stage{ID: id, Op: "map", Params: map[string]interface{}{"fn": "to_lower"}, Partitions: 2, Dependencies: ds})
+	}
+	return &Job{ID: "job-1", DAG: d, State: JobAccepted, CreatedAt: time.Now(), Priority: 1}
+}
+
+// stagesOf devuelve, ordenados y sin repetir, los stages de as.
+func stagesOf(as []*TaskAssignment) []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, a := range as {
+		if !seen[a.StageID] {
+			seen[a.StageID] = true
+			ids = append(ids, a.StageID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// finishStage completa las dos particiones del stage, cada una con el
+// resultado "<stage>-p<partición>", y devuelve lo que se encoló.
+func finishStage(t *testing.T, m *JobManager, stageID string) []*TaskAssignment {
+	t.Helper()
+	var enqueued []*TaskAssignment
+	m.EnqueueFn = func(a *TaskAssignment) { enqueued = append(enqueued, a) }
+	defer func() { m.EnqueueFn = nil }()
+	for p := 0; p < 2; p++ {
+		tid := taskID("job-1", stageID, p)
+		if !m.StartAttempt("job-1", tid, "a1") {
+			t.Fatalf("task %s is not runnable", tid)
+		}
+		result := fmt.Sprintf("%s-p%d", stageID, p)
+		if !m.CompleteTask("job-1", tid, "a1", func(jt *JobTask) { jt.Result = []interface{}{result} }) {
+			t.Fatalf("task %s was not completed", tid)
+		}
+	}
+	return enqueued
+}
+
+func TestStagesLaunchWhenDependenciesFinish(t *testing.T) {
+	type step struct {
+		finish   string
+		launched []string
+	}
+	cases := []struct {
+		name    string
+		deps    map[string][]string
+		sources []string
+		steps   []step
+	}{
+		{
+			name:    "chain",
+			deps:    map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}},
+			sources: []string{"a"},
+			steps:   []step{{"a", []string{"b"}}, {"b", []string{"c"}}, {"c", nil}},
+		},
+		{
+			name:    "diamond",
+			deps:    map[string][]string{"a": nil, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}},
+			sources: []string{"a"},
+			steps:   []step{{"a", []string{"b", "c"}}, {"b", nil}, {"c", []string{"d"}}, {"d", nil}},
+		},
+		{
+			name:    "two sources",
+			deps:    map[string][]string{"a": nil, "b": nil, "c": {"a", "b"}},
+			sources: []string{"a", "b"},
+			steps:   []step{{"b", nil}, {"a", []string{"c"}}, {"c", nil}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewJobManager()
+			job := newDAGJob(tc.deps)
+			m.Add(job)
+			if got := stagesOf(m.BuildTasks(job)); !reflect.DeepEqual(got, tc.sources) {
+				t.Fatalf("BuildTasks launched %v, want the sources %v", got, tc.sources)
+			}
+
+			for _, s := range tc.steps {
+				as := finishStage(t, m, s.finish)
+				if got, want := stagesOf(as), append([]string{}, s.launched...); !reflect.DeepEqual(got, want) {
+					t.Fatalf("finishing %s launched %v, want %v", s.finish, got, want)
+				}
+				// cada partición del hijo recibe la misma partición de cada padre
+				for _, a := range as {
+					var want []interface{}
+					for _, dep := range tc.deps[a.StageID] {
+						want = append(want, fmt.Sprintf("%s-p%d", dep, a.Partition))
+					}
+					if !reflect.DeepEqual(a.Input, want) {
+						t.Errorf("input of %s = %v, want %v", a.TaskID, a.Input, want)
+					}
+				}
+			}
+			if job.State != JobSuccess || job.Progress != 1 {
+				t.Errorf("job = %s at %.2f, want SUCCEEDED at 1", job.State, job.Progress)
+			}
+		})
+	}
+}
+
+func TestWideStageReadsShuffleOfEveryParent(t *testing.T) {
+	cases := []struct {
+		name        string
+		wide        *dag.Stage
+		partitioner string
+		sampled     bool
+	}{
+		{"hash", &dag.Stage{Op: "reduce_by_key", Params: map[string]interface{}{"key": "k", "fn": "sum"}}, dag.PartitionHash, false},
+		{"range", &dag.Stage{Op: "sort_by_key", Params: map[string]interface{}{"key": "k"},
+			Partitioner: &dag.PartitionerSpec{Type: dag.PartitionRange, Key: "k", Boundaries: []interface{}{"h", "p"}}}, dag.PartitionRange, false},
+		{"sampled range", &dag.Stage{Op: "sort_by_key", Params: map[string]interface{}{"key": "k"}}, dag.PartitionRange, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := dag.New()
+			d.AddStage(&dag.Stage{ID: "m", Op: "map", Params: map[string]interface{}{"fn": "to_lower"}, Partitions: 2})
+			tc.wide.ID, tc.wide.Partitions, tc.wide.Dependencies = "r", 3, []string{"m"}
+			d.AddStage(tc.wide)
+			job := &Job{ID: "job-1", DAG: d, State: JobAccepted, CreatedAt: time.Now(), Priority: 1}
+			m := NewJobManager()
+			m.Add(job)
+
+			// map-side: escribe el shuffle del hijo y no manda su salida al master
+			maps := m.BuildTasks(job)
+			if len(maps) != 2 {
+				t.Fatalf("built %d tasks, want the 2 partitions of m", len(maps))
+			}
+			for _, a := range maps {
+				if len(a.ShuffleWrites) != 1 || !a.DiscardOutput {
+					t.Fatalf("%s writes %v (discard %v), want one shuffle and no output", a.TaskID, a.ShuffleWrites, a.DiscardOutput)
+				}
+				sw := a.ShuffleWrites[0]
+				if sw.ShuffleID != "job-1-r" || sw.Buckets != 3 || sw.Partitioner.Type != tc.partitioner {
+					t.Errorf("%s writes %+v, want 3 %s buckets of job-1-r", a.TaskID, sw, tc.partitioner)
+				}
+			}
+
+			var reduces []*TaskAssignment
+			m.EnqueueFn = func(a *TaskAssignment) { reduces = append(reduces, a) }
+			for p, keys := range [][]interface{}{{"a", "k"}, {"q", "z"}} {
+				tid := taskID("job-1", "m", p)
+				host := fmt.Sprintf("http://w%d", p)
+				m.StartAttempt("job-1", tid, "a1")
+				m.CompleteTask("job-1", tid, "a1", func(jt *JobTask) {
+					jt.AssignedTo, jt.OutputHost = fmt.Sprintf("w%d", p), host
+					jt.KeySamples = map[string][]interface{}{"job-1-r": keys}
+				})
+			}
+
+			// reduce-side: cada partición lee su bucket de las dos tareas map
+			if len(reduces) != 3 {
+				t.Fatalf("launched %d tasks, want the 3 partitions of r", len(reduces))
+			}
+			for _, a := range reduces {
+				rd := a.ShuffleRead
+				if rd == nil || rd.ShuffleID != "job-1-r" || rd.Bucket != a.Partition || rd.Buckets != 3 {
+					t.Fatalf("%s reads %+v, want bucket %d of 3 of job-1-r", a.TaskID, rd, a.Partition)
+				}
+				want := []dag.ShuffleSource{
+					{Host: "http://w0", TaskID: taskID("job-1", "m", 0)},
+					{Host: "http://w1", TaskID: taskID("job-1", "m", 1)},
+				}
+				if !reflect.DeepEqual(rd.Sources, want) {
+					t.Errorf("%s reads from %v, want %v", a.TaskID, rd.Sources, want)
+				}
+				switch {
+				case tc.sampled && (rd.Partitioner == nil || len(rd.Partitioner.Boundaries) != 2):
+					t.Errorf("%s reads with %+v, want boundaries from the key sample", a.TaskID, rd.Partitioner)
+				case !tc.sampled && rd.Partitioner != nil:
+					t.Errorf("%s reads with %+v, want the buckets the map side wrote", a.TaskID, rd.Partitioner)
+				}
+			}
+		})
+	}
+}
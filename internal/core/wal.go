@@ -0,0 +1,642 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"batchdag/internal/dag"
+)
+
+// WAL guarda en disco el estado del master para que un reinicio no pierda
+// los jobs: cada cambio (alta de un job, tareas que cambian de estado,
+// stages que terminan, workers que caen o vuelven) se agrega como una línea
+// JSON a un log, y cada tanto se escribe un snapshot con todo el estado y se
+// empieza un log nuevo. Al arrancar se lee el snapshot y se aplica encima el
+// log que lo sigue (ver JobManager.Recover).
+//
+// Cada línea del log es un walEntry completo y se escribe de una vez: si el
+// master cae a mitad de una escritura, la última línea queda cortada y se
+// descarta entera al recuperar.
+//
+// Quien anota un cambio no toca el disco: la línea se encola y una sola
+// goroutine (run) escribe todo lo que se juntó y hace un único fsync por
+// tanda, sin m.mu tomado. Los snapshots van en la misma cola, así las
+// líneas anteriores quedan en el log viejo y las siguientes en el nuevo.
+// Quien necesita saber que un cambio ya está en disco lo espera con wait.
+type WAL struct {
+	dir string
+	mu  sync.Mutex
+	// cond despierta a run cuando hay algo en la cola y a los que esperan
+	// en wait cuando una tanda llega al disco
+	cond *sync.Cond
+	// f y gen (número del log actual, wal-<gen>.log; lo anota el snapshot)
+	// sólo los usa run
+	f   *os.File
+	gen int
+	// entries cuenta las líneas del log actual; a partir de SnapshotEvery
+	// el próximo cambio pide un snapshot
+	entries       int
+	SnapshotEvery int
+	// KeepFinished es cuántos jobs terminados (los más recientes) guarda el
+	// snapshot; los anteriores quedan en memoria pero no sobreviven a un
+	// reinicio del master (ver snapshotLocked)
+	KeepFinished int
+	// Fence, si no es nil, se llama antes de cada escritura en el log o en
+	// el snapshot; si devuelve un error no se escribe (ver Elector.Fence)
+	Fence   func() error
+	workers *WorkerRegistry
+
+	queue    []walWrite
+	queued   uint64 // número de la última escritura encolada
+	written  uint64 // número de la última escritura que ya pasó por el disco
+	failed   uint64 // escrituras hasta esta y desde failedFrom fallaron con err
+	failFrom uint64
+	err      error
+	snapping bool // hay un snapshot en la cola o escribiéndose
+	started  bool // run está corriendo
+	closed   bool
+	done     chan struct{}
+}
+
+// walWrite es una escritura pendiente: una línea del log o, si snapshot no
+// es nil, un snapshot con esos jobs que empieza un log nuevo.
+type walWrite struct {
+	seq      uint64
+	line     []byte
+	snapshot []byte
+}
+
+const (
+	snapshotFile         = "snapshot.json"
+	defaultSnapshotEvery = 1000
+	defaultKeepFinished  = 100
+)
+
+// walEntry es una línea del log o del snapshot. Job viene sólo en el alta
+// del job (y en el snapshot); un cambio posterior trae State y las tareas
+// que cambiaron. Gen sólo aparece en la primera línea del snapshot.
+type walEntry struct {
+	Gen    int          `json:"gen,omitempty"`
+	Job    *Job         `json:"job,omitempty"`
+	JobID  string       `json:"job_id,omitempty"`
+	State  *jobState    `json:"state,omitempty"`
+	Tasks  []taskRecord `json:"tasks,omitempty"`
+	Worker *WorkerInfo  `json:"worker,omitempty"`
+}
+
+// jobState es lo que cambia de un job mientras corre, sin sus tareas.
+type jobState struct {
+	State    JobState                `json:"state"`
+	Stages   map[string]*StageStatus `json:"stages"`
+	Progress float32                 `json:"progress"`
+	Error    string                  `json:"error,omitempty"`
+}
+
+// taskRecord es una JobTask con su resultado y sus muestras de claves, que
+// la API no muestra pero hacen falta para lanzar los stages siguientes.
+type taskRecord struct {
+	*JobTask
+	Result     []interface{}            `json:"result,omitempty"`
+	KeySamples map[string][]interface{} `json:"key_samples,omitempty"`
+}
+
+func newTaskRecord(t *JobTask) taskRecord {
+	return taskRecord{JobTask: t, Result: t.Result, KeySamples: t.KeySamples}
+}
+
+// OpenWAL prepara dir para guardar el estado del master. El log se abre
+// recién en Recover, después de leer lo que quedó de la corrida anterior.
+func OpenWAL(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		dir:           dir,
+		SnapshotEvery: defaultSnapshotEvery,
+		KeepFinished:  defaultKeepFinished,
+		done:          make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	return w, nil
+}
+
+func (w *WAL) logPath(gen int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("wal-%d.log", gen))
+}
+
+// load lee el snapshot y el log que lo sigue, en orden.
+func (w *WAL) load() ([]walEntry, error) {
+	snap, err := readEntries(filepath.Join(w.dir, snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	if len(snap) > 0 {
+		w.gen = snap[0].Gen
+	}
+	// los demás logs son el anterior al snapshot, si el master cayó antes
+	// de borrarlo, o uno que empezó un snapshot que no llegó a reemplazar a
+	// snapshot.json: ninguno tiene nada que no esté en el snapshot
+	logs, _ := filepath.Glob(filepath.Join(w.dir, "wal-*.log"))
+	for _, path := range logs {
+		if path != w.logPath(w.gen) {
+			os.Remove(path)
+		}
+	}
+	rest, err := readEntries(w.logPath(w.gen))
+	if err != nil {
+		return nil, err
+	}
+	return append(snap, rest...), nil
+}
+
+// readEntries lee un archivo de walEntry, uno por línea. Un archivo que no
+// existe está vacío; una línea que no se puede decodificar (la última, si el
+// master cayó mientras la escribía) corta la lectura.
+func readEntries(path string) ([]walEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []walEntry
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var e walEntry
+			if jerr := json.Unmarshal(line, &e); jerr != nil {
+				log.Printf("wal: ignoring truncated entry at the end of %s\n", path)
+				return out, nil
+			}
+			out = append(out, e)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return out, nil
+}
+
+// start escribe el snapshot inicial (los jobs recuperados), que empieza el
+// primer log, y arranca la goroutine que escribe lo que se encole.
+func (w *WAL) start(jobs []byte) error {
+	if err := w.rotate(jobs); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.started = true
+	w.mu.Unlock()
+	go w.run()
+	return nil
+}
+
+// append encola e para el log y devuelve su número, para esperarla con wait.
+func (w *WAL) append(e *walEntry) (uint64, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, fmt.Errorf("wal is closed")
+	}
+	w.entries++
+	return w.pushLocked(walWrite{line: append(b, '\n')}), nil
+}
+
+// snapshot encola un snapshot con jobs (ver JobManager.snapshotLocked).
+func (w *WAL) snapshot(jobs []byte) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0
+	}
+	w.snapping = true
+	w.entries = 0
+	return w.pushLocked(walWrite{snapshot: jobs})
+}
+
+func (w *WAL) pushLocked(op walWrite) uint64 {
+	w.queued++
+	op.seq = w.queued
+	w.queue = append(w.queue, op)
+	w.cond.Broadcast()
+	return op.seq
+}
+
+// due indica si ya toca pedir un snapshot.
+func (w *WAL) due() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.snapping && w.entries >= w.SnapshotEvery
+}
+
+// wait espera a que la escritura seq (y las anteriores) pase por el disco,
+// y devuelve el error si no se pudo escribir.
+func (w *WAL) wait(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.written < seq {
+		w.cond.Wait()
+	}
+	if seq >= w.failFrom && seq <= w.failed {
+		return w.err
+	}
+	return nil
+}
+
+// Close escribe lo que queda en la cola y cierra el log.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	started := w.started
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	if !started {
+		return nil
+	}
+	<-w.done
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
+
+// run escribe la cola por tandas hasta que se cierra el WAL.
+func (w *WAL) run() {
+	defer close(w.done)
+	w.mu.Lock()
+	for {
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		batch := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+
+		err := w.write(batch)
+
+		w.mu.Lock()
+		last := batch[len(batch)-1].seq
+		if err != nil {
+			log.Printf("wal: cannot write: %v\n", err)
+			w.failFrom, w.failed, w.err = batch[0].seq, last, err
+		}
+		for _, op := range batch {
+			if op.snapshot != nil {
+				w.snapping = false
+			}
+		}
+		w.written = last
+		w.cond.Broadcast()
+	}
+}
+
+// write escribe una tanda: las líneas van juntas al log actual con un solo
+// fsync, y cada snapshot las escribe antes de empezar el log nuevo. Un
+// snapshot que falla deja el log actual, así que no pierde nada.
+func (w *WAL) write(batch []walWrite) error {
+	var buf []byte
+	var err error
+	for _, op := range batch {
+		if op.snapshot == nil {
+			buf = append(buf, op.line...)
+			continue
+		}
+		if ferr := w.writeLog(buf); ferr != nil {
+			err = ferr
+		}
+		buf = nil
+		if serr := w.rotate(op.snapshot); serr != nil {
+			log.Printf("wal: cannot write snapshot: %v\n", serr)
+		}
+	}
+	if ferr := w.writeLog(buf); ferr != nil {
+		err = ferr
+	}
+	return err
+}
+
+func (w *WAL) writeLog(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := w.fence(); err != nil {
+		return err
+	}
+	if _, err := w.f.Write(b); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// rotate reemplaza snapshot.json por jobs más los workers del registry y
+// empieza un log nuevo. El log nuevo se crea antes de cambiar el snapshot:
+// si algo falla el snapshot anterior y el log actual quedan como estaban y
+// se sigue escribiendo en ese log. El snapshot anota el número del log que
+// lo sigue: si el master cae antes de borrar el log anterior, al recuperar
+// ese log se ignora. El log nuevo no puede existir: si existe es de otro
+// master que ya siguió desde este estado, y no se pisa.
+func (w *WAL) rotate(jobs []byte) error {
+	if err := w.fence(); err != nil {
+		return err
+	}
+	gen := w.gen + 1
+	next, err := os.OpenFile(w.logPath(gen), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := w.writeSnapshot(gen, jobs); err != nil {
+		next.Close()
+		os.Remove(w.logPath(gen))
+		return err
+	}
+	if w.f != nil {
+		w.f.Close()
+	}
+	os.Remove(w.logPath(w.gen))
+	w.f, w.gen = next, gen
+	return nil
+}
+
+func (w *WAL) fence() error {
+	if w.Fence == nil {
+		return nil
+	}
+	return w.Fence()
+}
+
+// writeSnapshot escribe el snapshot en un archivo aparte y lo cambia por
+// snapshot.json con un rename.
+func (w *WAL) writeSnapshot(gen int, jobs []byte) error {
+	tmp := filepath.Join(w.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	err = enc.Encode(&walEntry{Gen: gen})
+	if err == nil {
+		_, err = bw.Write(jobs)
+	}
+	if w.workers != nil {
+		for _, wk := range w.workers.Snapshot() {
+			if err == nil {
+				err = enc.Encode(&walEntry{Worker: &wk})
+			}
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(w.dir, snapshotFile))
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// workerChanged anota en el log el nuevo estado de un worker.
+func (w *WAL) workerChanged(wk WorkerInfo, _ WorkerState) {
+	if _, err := w.append(&walEntry{Worker: &wk}); err != nil {
+		log.Printf("wal: cannot log worker %s: %v\n", wk.ID, err)
+	}
+}
+
+// Recover reconstruye desde wal los jobs y los workers que tenía el master
+// antes de reiniciar y a partir de ahí anota en wal cada cambio. Devuelve
+// las tareas sin terminar de los jobs en curso para volver a encolarlas:
+// los intentos que estaban corriendo se pierden y sus reportes, si llegan,
+// se descartan. Los jobs ACCEPTED quedan para quien los construye.
+func (m *JobManager) Recover(wal *WAL, reg *WorkerRegistry) ([]*TaskAssignment, error) {
+	entries, err := wal.load()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workers := map[string]WorkerInfo{}
+	for _, e := range entries {
+		if e.Worker != nil {
+			workers[e.Worker.ID] = *e.Worker
+		}
+		if e.Job != nil {
+			j := e.Job
+			if j.Tasks == nil {
+				j.Tasks = make(map[string]*JobTask)
+			}
+			m.jobs[j.ID] = j
+		}
+		id := e.JobID
+		if e.Job != nil {
+			id = e.Job.ID
+		}
+		j, ok := m.jobs[id]
+		if !ok {
+			continue
+		}
+		if s := e.State; s != nil {
+			j.State, j.Stages, j.Progress, j.Error = s.State, s.Stages, s.Progress, s.Error
+		}
+		for _, rec := range e.Tasks {
+			t := rec.JobTask
+			if t == nil {
+				continue
+			}
+			t.Result, t.KeySamples = rec.Result, rec.KeySamples
+			j.Tasks[t.ID] = t
+		}
+	}
+
+	restored := make([]WorkerInfo, 0, len(workers))
+	for _, w := range workers {
+		restored = append(restored, w)
+	}
+	reg.Restore(restored)
+
+	// relanzar lo que quedó sin terminar en los stages que estaban corriendo
+	var out []*TaskAssignment
+	running := 0
+	for _, j := range m.jobs {
+		if j.State != JobRunning {
+			continue
+		}
+		running++
+		ids := make([]string, 0, len(j.Stages))
+		for id, ss := range j.Stages {
+			if ss.State == StageRunning {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			st := j.DAG.Stages[id]
+			if ss := j.Stages[id]; dag.IsSinkOp(st.Op) && ss.Done == ss.Partitions {
+				// el master cayó durante el commit: retomarlo (corre cuando
+				// Recover suelte m.mu)
+				if c, err := m.sinkCommitLocked(j, st); err == nil {
+					go m.commitSink(c)
+				} else {
+					m.failStageLocked(j, id, "commit failed: "+err.Error())
+				}
+				continue
+			}
+			out = append(out, m.launchTasksLocked(j, st, nil)...)
+		}
+		j.dirty = nil
+	}
+	if len(m.jobs) > 0 || len(restored) > 0 {
+		log.Printf("wal: recovered %d jobs (%d running) and %d workers, re-enqueuing %d tasks\n",
+			len(m.jobs), running, len(restored), len(out))
+	}
+
+	// el snapshot inicial deja el estado recuperado como punto de partida
+	wal.workers = reg
+	m.wal = wal
+	jobs, err := m.snapshotLocked()
+	if err == nil {
+		err = wal.start(jobs)
+	}
+	if err != nil {
+		m.wal = nil
+		return nil, err
+	}
+	reg.Subscribe(wal.workerChanged)
+	return out, nil
+}
+
+// persistLocked anota en el log el estado del job y las tareas que
+// cambiaron desde la última vez (ver Job.touch) y avisa si el job terminó.
+// Debe llamarse con m.mu tomado.
+func (m *JobManager) persistLocked(job *Job) {
+	m.finishedLocked(job)
+	if m.wal == nil {
+		job.dirty = nil
+		return
+	}
+	e := &walEntry{
+		JobID: job.ID,
+		State: &jobState{
+			State:    job.State,
+			Stages:   job.Stages,
+			Progress: job.Progress,
+			Error:    job.Error,
+		},
+	}
+	for t := range job.dirty {
+		e.Tasks = append(e.Tasks, newTaskRecord(t))
+	}
+	job.dirty = nil
+	m.appendLocked(e)
+}
+
+// appendLocked encola e para el log y, si ya toca, un snapshot, y devuelve
+// el número de la escritura (ver WAL.wait). Si el disco falla el master
+// sigue con el estado en memoria.
+func (m *JobManager) appendLocked(e *walEntry) uint64 {
+	if m.wal == nil {
+		return 0
+	}
+	seq, err := m.wal.append(e)
+	if err != nil {
+		log.Printf("wal: cannot append entry: %v\n", err)
+	}
+	if m.wal.due() {
+		if jobs, err := m.snapshotLocked(); err != nil {
+			log.Printf("wal: cannot write snapshot: %v\n", err)
+		} else {
+			m.wal.snapshot(jobs)
+		}
+	}
+	return seq
+}
+
+// snapshotLocked serializa los jobs para un snapshot: los que siguen en
+// curso y los wal.KeepFinished terminados más recientes, sin las muestras
+// de claves, que sólo sirven para lanzar stages. Los terminados más viejos
+// no entran, para que el snapshot no crezca con cada job que corre, pero
+// siguen en memoria hasta que el master reinicie. Debe llamarse con m.mu
+// tomado.
+func (m *JobManager) snapshotLocked() ([]byte, error) {
+	var finished []*Job
+	for _, j := range m.jobs {
+		if j.State.Finished() {
+			finished = append(finished, j)
+		}
+	}
+	old := map[string]bool{}
+	if keep := max(m.wal.KeepFinished, 0); len(finished) > keep {
+		sort.Slice(finished, func(a, b int) bool { return finished[a].CreatedAt.After(finished[b].CreatedAt) })
+		for _, j := range finished[keep:] {
+			old[j.ID] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, j := range m.jobs {
+		if old[j.ID] {
+			continue
+		}
+		e := &walEntry{Job: jobHeader(j)}
+		for _, t := range j.Tasks {
+			rec := newTaskRecord(t)
+			if j.State.Finished() {
+				rec.KeySamples = nil
+			}
+			e.Tasks = append(e.Tasks, rec)
+		}
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// jobHeader es una copia del job sin sus tareas, que van aparte.
+func jobHeader(j *Job) *Job {
+	h := *j
+	h.Tasks = nil
+	h.dirty = nil
+	h.ended = false
+	return &h
+}
+
+// touch anota que la tarea t cambió y hay que guardarla.
+func (j *Job) touch(t *JobTask) {
+	if j.dirty == nil {
+		j.dirty = make(map[*JobTask]bool)
+	}
+	j.dirty[t] = true
+}
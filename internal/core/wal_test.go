@@ -0,0 +1,190 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"batchdag/internal/dag"
+)
+
+// newTestJob arma un job de un stage map con parts particiones.
+func newTestJob(id string, parts int) *Job {
+	d := dag.New()
+	d.AddStage(&dag.Stage{ID: "s1", Op: "map", Params: map[string]interface{}{"fn": "to_lower"}, Partitions: parts})
+	return &Job{ID: id, DAG: d, State: JobAccepted, CreatedAt: time.Now(), Priority: 1}
+}
+
+// completeTask corre un intento de la tarea p del stage s1 hasta DONE.
+func completeTask(t *testing.T, m *JobManager, jobID string, p int, result string) {
+	t.Helper()
+	tid := taskID(jobID, "s1", p)
+	if !m.StartAttempt(jobID, tid, "a1") {
+		t.Fatalf("task %s is not runnable", tid)
+	}
+	ok := m.CompleteTask(jobID, tid, "a1", func(jt *JobTask) {
+		jt.Result = []interface{}{result}
+	})
+	if !ok {
+		t.Fatalf("task %s was not completed", tid)
+	}
+}
+
+// openState recupera el estado guardado en dir en un JobManager nuevo.
+func openState(t *testing.T, dir string, setup func(w *WAL)) (*JobManager, *WAL, []*TaskAssignment) {
+	t.Helper()
+	w, err := OpenWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup != nil {
+		setup(w)
+	}
+	m := NewJobManager()
+	pending, err := m.Recover(w, NewWorkerRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, w, pending
+}
+
+func TestWALRecoversJobs(t *testing.T) {
+	dir := t.TempDir()
+	m, w, _ := openState(t, dir, nil)
+	job := newTestJob("job-1", 2)
+	m.Add(job)
+	m.BuildTasks(job)
+	completeTask(t, m, "job-1", 0, "x")
+	w.Close()
+
+	m, w, pending := openState(t, dir, nil)
+	defer w.Close()
+	j, ok := m.Get("job-1")
+	if !ok {
+		t.Fatal("job was not recovered")
+	}
+	if j.State != JobRunning {
+		t.Errorf("job state = %s, want %s", j.State, JobRunning)
+	}
+	done := j.Tasks[taskID("job-1", "s1", 0)]
+	if done.Status != "DONE" || len(done.Result) != 1 || done.Result[0] != "x" {
+		t.Errorf("finished task = %s %v, want DONE with its result", done.Status, done.Result)
+	}
+	if len(pending) != 1 || pending[0].Partition != 1 {
+		t.Errorf("re-enqueued %d tasks, want only partition 1", len(pending))
+	}
+}
+
+func TestWALSnapshotStartsNewLog(t *testing.T) {
+	dir := t.TempDir()
+	m, w, _ := openState(t, dir, func(w *WAL) { w.SnapshotEvery = 2 })
+	job := newTestJob("job-1", 3)
+	m.Add(job)
+	m.BuildTasks(job)
+	for p := 0; p < 3; p++ {
+		completeTask(t, m, "job-1", p, "x")
+	}
+	w.Close()
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if w.gen < 2 || len(logs) != 1 || logs[0] != w.logPath(w.gen) {
+		t.Errorf("logs after snapshots = %v (generation %d), want only the current one", logs, w.gen)
+	}
+
+	m, w, _ = openState(t, dir, nil)
+	defer w.Close()
+	if j, ok := m.Get("job-1"); !ok || j.State != JobSuccess {
+		t.Errorf("recovered job = %+v, want it SUCCEEDED", j)
+	}
+}
+
+func TestWALIgnoresTornTail(t *testing.T) {
+	dir := t.TempDir()
+	m, w, _ := openState(t, dir, nil)
+	job := newTestJob("job-1", 2)
+	m.Add(job)
+	m.BuildTasks(job)
+	completeTask(t, m, "job-1", 0, "x")
+	w.Close()
+
+	// el master cayó a mitad de una línea
+	f, err := os.OpenFile(w.logPath(w.gen), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"job_id":"job-1","tasks":[{"id":`)
+	f.Close()
+
+	m, w, _ = openState(t, dir, nil)
+	defer w.Close()
+	j, ok := m.Get("job-1")
+	if !ok || j.Tasks[taskID("job-1", "s1", 0)].Status != "DONE" {
+		t.Errorf("entries before the torn line were not recovered")
+	}
+}
+
+func TestWALSnapshotFailureKeepsLog(t *testing.T) {
+	dir := t.TempDir()
+	m, w, _ := openState(t, dir, nil)
+	job := newTestJob("job-1", 2)
+	m.Add(job)
+	m.BuildTasks(job)
+
+	// el snapshot no se puede escribir: sigue el log actual
+	tmp := filepath.Join(dir, snapshotFile+".tmp")
+	os.Mkdir(tmp, 0o755)
+	gen := w.gen
+	w.mu.Lock()
+	w.entries = w.SnapshotEvery
+	w.mu.Unlock()
+	completeTask(t, m, "job-1", 0, "x")
+	completeTask(t, m, "job-1", 1, "y")
+	w.Close()
+	os.Remove(tmp)
+
+	if w.gen != gen {
+		t.Errorf("log generation = %d after a failed snapshot, want %d", w.gen, gen)
+	}
+	m, w, _ = openState(t, dir, nil)
+	defer w.Close()
+	if j, ok := m.Get("job-1"); !ok || j.State != JobSuccess {
+		t.Errorf("changes logged around a failed snapshot were lost")
+	}
+}
+
+func TestSnapshotKeepsRecentFinishedJobs(t *testing.T) {
+	dir := t.TempDir()
+	m, w, _ := openState(t, dir, func(w *WAL) { w.KeepFinished = 1 })
+	for i, id := range []string{"job-1", "job-2", "job-3"} {
+		job := newTestJob(id, 1)
+		job.CreatedAt = time.Now().Add(time.Duration(i) * time.Second)
+		m.Add(job)
+		m.BuildTasks(job)
+		if id != "job-3" {
+			completeTask(t, m, id, 0, "x")
+		}
+	}
+	m.mu.Lock()
+	jobs, err := m.snapshotLocked()
+	m.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.snapshot(jobs)
+	w.Close()
+	if _, ok := m.Get("job-1"); !ok {
+		t.Errorf("the snapshot dropped an old finished job from memory")
+	}
+
+	m, w, _ = openState(t, dir, nil)
+	defer w.Close()
+	if _, ok := m.Get("job-1"); ok {
+		t.Errorf("an old finished job was kept in the snapshot")
+	}
+	for _, id := range []string{"job-2", "job-3"} {
+		if _, ok := m.Get(id); !ok {
+			t.Errorf("%s was dropped", id)
+		}
+	}
+}
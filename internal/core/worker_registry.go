@@ -12,16 +12,54 @@ const (
     WorkerDown WorkerState = "DOWN"
 )
 
+// WorkerMode indica cómo recibe tareas el worker: en push el master se las
+// manda a Host; en pull el worker las pide (POST /api/v1/tasks/lease), así
+// el master no necesita llegar a su dirección.
+type WorkerMode string
+
+const (
+    WorkerPush WorkerMode = "push"
+    WorkerPull WorkerMode = "pull"
+)
+
+// WorkerResources es lo que declara un worker al registrarse y en cada
+// heartbeat: cuántas tareas corre a la vez (slots), sus CPUs y su memoria.
+// CPUs o memoria en 0 quiere decir que no las sabe: no tienen límite.
+type WorkerResources struct {
+    Slots    int   `json:"slots"`
+    CPUs     int   `json:"cpus"`
+    MemoryMB int64 `json:"memory_mb"`
+}
+
 type WorkerInfo struct {
     ID        string    `json:"id"`
     Host      string    `json:"host"`
     LastBeat  time.Time `json:"lastBeat"`
     State     WorkerState `json:"state"`
+    Mode      WorkerMode  `json:"mode"`
+    Resources WorkerResources `json:"resources"`
+
+    // restored: lo conocía el master antes de reiniciar y todavía no se
+    // reconcilió lo que tiene (ver Reconciled). No cambia una vez publicado:
+    // Reconciled reemplaza al WorkerInfo.
+    restored bool
 }
 
+// Restored indica si el worker viene del estado anterior a un reinicio del
+// master y todavía no se reconcilió: no hay que mandarle tareas, porque el
+// master no sabe cuáles tiene corriendo.
+func (w *WorkerInfo) Restored() bool {
+    return w.restored
+}
+
+// WorkerListener recibe una copia del worker cuyo estado cambió y el estado
+// anterior ("" si el worker es nuevo, el mismo si sólo se reconcilió).
+type WorkerListener func(w WorkerInfo, prev WorkerState)
+
 type WorkerRegistry struct {
-    Workers map[string]*WorkerInfo
-    mu      sync.RWMutex
+    Workers   map[string]*WorkerInfo
+    mu        sync.RWMutex
+    listeners []WorkerListener
 }
 
 func NewWorkerRegistry() *WorkerRegistry {
@@ -30,38 +68,140 @@ func NewWorkerRegistry() *WorkerRegistry {
     }
 }
 
-func (r *WorkerRegistry) Register(id, host string) {
+// Subscribe registra fn para que reciba los cambios de estado de los
+// workers. Se llama fuera del lock del registry.
+func (r *WorkerRegistry) Subscribe(fn WorkerListener) {
     r.mu.Lock()
     defer r.mu.Unlock()
+    r.listeners = append(r.listeners, fn)
+}
+
+type stateChange struct {
+    w    WorkerInfo
+    prev WorkerState
+}
+
+func (r *WorkerRegistry) notify(changes []stateChange) {
+    r.mu.RLock()
+    listeners := r.listeners
+    r.mu.RUnlock()
 
-    r.Workers[id] = &WorkerInfo{
-        ID:       id,
-        Host:     host,
-        LastBeat: time.Now(),
-        State:    WorkerUp,
+    for _, c := range changes {
+        for _, fn := range listeners {
+            fn(c.w, c.prev)
+        }
     }
 }
 
-func (r *WorkerRegistry) Heartbeat(id string) {
+// Register da de alta (o vuelve a dar de alta) un worker. Un worker que no
+// declara slots corre una tarea a la vez. Uno recuperado del WAL sigue sin
+// recibir tareas hasta que se reconcilia lo que informa (ver Reconciled).
+func (r *WorkerRegistry) Register(id, host string, mode WorkerMode, res WorkerResources) {
+    if mode == "" {
+        mode = WorkerPush
+    }
+    if res.Slots <= 0 {
+        res.Slots = 1
+    }
     r.mu.Lock()
-    defer r.mu.Unlock()
+    var prev WorkerState
+    restored := false
+    if old, ok := r.Workers[id]; ok {
+        prev = old.State
+        restored = old.restored
+    }
+    w := &WorkerInfo{
+        ID:        id,
+        Host:      host,
+        LastBeat:  time.Now(),
+        State:     WorkerUp,
+        Mode:      mode,
+        Resources: res,
+        restored:  restored,
+    }
+    r.Workers[id] = w
+    change := stateChange{*w, prev}
+    r.mu.Unlock()
 
-    if w, ok := r.Workers[id]; ok {
+    if prev != WorkerUp {
+        r.notify([]stateChange{change})
+    }
+}
+
+// Heartbeat marca al worker como vivo y, si el heartbeat trae recursos,
+// actualiza los que tenía declarados. Devuelve false si el worker tiene que
+// volver a registrarse: el master no lo conoce (p.ej. reinició sin estado)
+// o lo recuperó del WAL y todavía no sabe qué tareas y salidas tiene.
+func (r *WorkerRegistry) Heartbeat(id string, res WorkerResources) bool {
+    r.mu.Lock()
+    var changes []stateChange
+    w, ok := r.Workers[id]
+    if ok {
         w.LastBeat = time.Now()
-        w.State = WorkerUp
+        if res.Slots > 0 {
+            w.Resources = res
+        }
+        if w.State != WorkerUp {
+            prev := w.State
+            w.State = WorkerUp
+            changes = append(changes, stateChange{*w, prev})
+        }
     }
+    known := ok && !w.restored
+    r.mu.Unlock()
+
+    r.notify(changes)
+    return known
 }
 
-func (r *WorkerRegistry) DetectDown(threshold time.Duration) {
+// Reconciled marca que ya se reconcilió lo que informó el worker id al
+// volver a registrarse: desde ahora recibe tareas como cualquiera, y se
+// avisa a los listeners como un cambio de estado (con prev igual al estado
+// actual).
+func (r *WorkerRegistry) Reconciled(id string) {
     r.mu.Lock()
-    defer r.mu.Unlock()
+    var changes []stateChange
+    if w, ok := r.Workers[id]; ok && w.restored {
+        c := *w
+        c.restored = false
+        r.Workers[id] = &c
+        changes = append(changes, stateChange{c, c.State})
+    }
+    r.mu.Unlock()
+
+    r.notify(changes)
+}
+
+// NeedsRegister indica si el worker id tiene que volver a registrarse
+// antes de que el master acepte lo que informa (ver Heartbeat).
+func (r *WorkerRegistry) NeedsRegister(id string) bool {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    w, ok := r.Workers[id]
+    return !ok || w.restored
+}
 
+func (r *WorkerRegistry) DetectDown(threshold time.Duration) {
+    r.mu.Lock()
+    var changes []stateChange
     now := time.Now()
     for _, w := range r.Workers {
-        if now.Sub(w.LastBeat) > threshold {
+        if now.Sub(w.LastBeat) > threshold && w.State != WorkerDown {
+            prev := w.State
             w.State = WorkerDown
+            changes = append(changes, stateChange{*w, prev})
         }
     }
+    r.mu.Unlock()
+
+    r.notify(changes)
+}
+
+func (r *WorkerRegistry) Get(id string) (*WorkerInfo, bool) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    w, ok := r.Workers[id]
+    return w, ok
 }
 
 func (r *WorkerRegistry) List() []*WorkerInfo {
@@ -74,3 +214,33 @@ func (r *WorkerRegistry) List() []*WorkerInfo {
     }
     return workers
 }
+
+// Snapshot devuelve una copia de todos los workers.
+func (r *WorkerRegistry) Snapshot() []WorkerInfo {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    out := make([]WorkerInfo, 0, len(r.Workers))
+    for _, w := range r.Workers {
+        out = append(out, *w)
+    }
+    return out
+}
+
+// Restore vuelve a cargar los workers que conocía el master antes de
+// reiniciar. Los que estaban UP cuentan su último heartbeat desde ahora:
+// si no vuelven a latir, DetectDown los da por caídos como a cualquiera.
+// Todos tienen que volver a registrarse (ver Heartbeat).
+func (r *WorkerRegistry) Restore(workers []WorkerInfo) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    now := time.Now()
+    for _, w := range workers {
+        if w.State == WorkerUp {
+            w.LastBeat = now
+        }
+        w.restored = true
+        r.Workers[w.ID] = &w
+    }
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"sort"
+	"time"
 )
 
 // DAG representa un grafo de stages y sus dependencias.
@@ -28,6 +30,40 @@ func (d *DAG) GetStage(id string) *Stage {
 	return d.Stages[id]
 }
 
+// NumPartitions devuelve cuántas particiones tiene un stage: las que
+// declara o, si no declara, el máximo de sus dependencias (1 para fuentes).
+func (d *DAG) NumPartitions(st *Stage) int {
+	if st.Partitions > 0 {
+		return st.Partitions
+	}
+	parts := 1
+	for _, dep := range st.Dependencies {
+		if n := d.NumPartitions(d.Stages[dep]); n > parts {
+			parts = n
+		}
+	}
+	return parts
+}
+
+// Leaves devuelve, ordenados, los stages de los que no depende ningún otro:
+// los que producen el resultado final del job.
+func (d *DAG) Leaves() []string {
+	hasChild := map[string]bool{}
+	for _, st := range d.Stages {
+		for _, dep := range st.Dependencies {
+			hasChild[dep] = true
+		}
+	}
+	var out []string
+	for id := range d.Stages {
+		if !hasChild[id] {
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
 // LoadFromFile carga un DAG desde un archivo JSON y lo valida.
 func LoadFromFile(path string) (*DAG, error) {
 	b, err := ioutil.ReadFile(path)
@@ -70,5 +106,85 @@ func LoadFromBytes(b []byte) (*DAG, error) {
 		return nil, errors.New("dag contains cycle: " + cycle)
 	}
 
+	for _, st := range d.Stages {
+		if err := d.validateShuffle(st); err != nil {
+			return nil, err
+		}
+		if IsSinkOp(st.Op) {
+			if len(st.Dependencies) == 0 {
+				return nil, errors.New("stage " + st.ID + ": op " + st.Op + " requires dependencies")
+			}
+			if p, _ := st.Params["path"].(string); p == "" {
+				return nil, errors.New("stage " + st.ID + ": op " + st.Op + " requires params.path")
+			}
+		}
+		if st.Timeout != "" {
+			if d, err := time.ParseDuration(st.Timeout); err != nil || d <= 0 {
+				return nil, errors.New("stage " + st.ID + ": invalid timeout " + st.Timeout)
+			}
+		}
+		if st.Persist != "" {
+			if !ValidPersist(st.Persist) {
+				return nil, errors.New("stage " + st.ID + ": invalid persist " + st.Persist)
+			}
+			if IsSinkOp(st.Op) {
+				return nil, errors.New("stage " + st.ID + ": op " + st.Op + " cannot be persisted")
+			}
+		}
+		if st.TaskMemoryMB() < 0 {
+			return nil, errors.New("stage " + st.ID + ": resources.memory_mb must not be negative")
+		}
+		if st.TaskCPUs() < 0 {
+			return nil, errors.New("stage " + st.ID + ": resources.cpus must not be negative")
+		}
+		if c, ok := st.Params["compression"].(string); ok && IsSourceOp(st.Op) {
+			if _, err := Compression("", c); err != nil {
+				return nil, errors.New("stage " + st.ID + ": " + err.Error())
+			}
+		}
+	}
+
 	return d, nil
 }
+
+// validateShuffle revisa los stages anchos: necesitan dependencias, una
+// clave si agrupan, y un partitioner válido y coherente con esa clave.
+func (d *DAG) validateShuffle(st *Stage) error {
+	if !st.IsWide() {
+		return nil
+	}
+	prefix := "stage " + st.ID + ": "
+	if len(st.Dependencies) == 0 {
+		return errors.New(prefix + "shuffle requires dependencies")
+	}
+
+	key := st.ShuffleKey()
+	if IsWideOp(st.Op) && key == "" {
+		return errors.New(prefix + "op " + st.Op + " requires params.key")
+	}
+
+	spec := st.Partitioner
+	if spec == nil {
+		return nil
+	}
+	if spec.Key == "" {
+		spec.Key = key
+	}
+	if err := spec.Validate(d.NumPartitions(st)); err != nil {
+		return errors.New(prefix + err.Error())
+	}
+	// los ops que agrupan necesitan que una misma clave caiga siempre en la
+	// misma partición
+	if IsWideOp(st.Op) {
+		if spec.Type == PartitionRoundRobin {
+			return errors.New(prefix + "op " + st.Op + " cannot use a round_robin partitioner")
+		}
+		if spec.Type != PartitionCustom && spec.Key != key {
+			return errors.New(prefix + "partitioner key must match params.key")
+		}
+	}
+	if st.Op == "sort_by_key" && spec.Type != PartitionRange {
+		return errors.New(prefix + "op sort_by_key requires a range partitioner")
+	}
+	return nil
+}
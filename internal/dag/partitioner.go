@@ -0,0 +1,272 @@
+package dag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// PartitionerSpec es el bloque "partitioner" de un stage: decide cómo se
+// reparten entre sus particiones los registros que recibe por shuffle.
+//
+//	{"type": "hash", "key": "token"}
+//	{"type": "range", "key": "ts", "sample_size": 200}
+//	{"type": "range", "key": "age", "boundaries": [18, 65]}
+//	{"type": "round_robin"}
+//	{"type": "custom", "name": "mi_particionador", "params": {...}}
+//
+// Un range sin boundaries las calcula a partir de una muestra de claves que
+// devuelven las tareas map-side.
+type PartitionerSpec struct {
+	Type       string                 `json:"type"`
+	Key        string                 `json:"key,omitempty"`
+	Boundaries []interface{}          `json:"boundaries,omitempty"`
+	SampleSize int                    `json:"sample_size,omitempty"`
+	Name       string                 `json:"name,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+}
+
+const (
+	PartitionHash       = "hash"
+	PartitionRange      = "range"
+	PartitionRoundRobin = "round_robin"
+	PartitionCustom     = "custom"
+
+	defaultSampleSize = 100
+)
+
+// Partitioner decide a qué partición va cada registro.
+type Partitioner interface {
+	NumPartitions() int
+	Partition(rec map[string]interface{}) int
+}
+
+// PartitionerFactory construye un Partitioner con n particiones.
+type PartitionerFactory func(spec *PartitionerSpec, n int) (Partitioner, error)
+
+// customPartitioners son los particionadores registrados por nombre para
+// "type": "custom". Deben registrarse tanto en el master (validación) como
+// en los workers (ejecución).
+var customPartitioners = map[string]PartitionerFactory{}
+
+// RegisterPartitioner agrega un particionador custom.
+func RegisterPartitioner(name string, f PartitionerFactory) {
+	customPartitioners[name] = f
+}
+
+// NewPartitioner construye el Partitioner descrito por spec.
+func NewPartitioner(spec *PartitionerSpec, n int) (Partitioner, error) {
+	if n <= 0 {
+		n = 1
+	}
+	switch spec.Type {
+	case PartitionHash:
+		return &hashPartitioner{key: spec.Key, n: n}, nil
+	case PartitionRange:
+		if len(spec.Boundaries) != n-1 {
+			return nil, fmt.Errorf("range partitioner needs %d boundaries, got %d", n-1, len(spec.Boundaries))
+		}
+		return &rangePartitioner{key: spec.Key, bounds: spec.Boundaries}, nil
+	case PartitionRoundRobin:
+		return &roundRobinPartitioner{n: n, next: rand.Intn(n)}, nil
+	case PartitionCustom:
+		f, ok := customPartitioners[spec.Name]
+		if !ok {
+			return nil, errors.New("unknown custom partitioner: " + spec.Name)
+		}
+		return f(spec, n)
+	}
+	return nil, errors.New("unknown partitioner type: " + spec.Type)
+}
+
+// Validate revisa el bloque para un stage con n particiones.
+func (spec *PartitionerSpec) Validate(n int) error {
+	switch spec.Type {
+	case PartitionHash:
+		if spec.Key == "" {
+			return errors.New("hash partitioner requires key")
+		}
+	case PartitionRange:
+		if spec.Key == "" {
+			return errors.New("range partitioner requires key")
+		}
+		if len(spec.Boundaries) > 0 && len(spec.Boundaries) != n-1 {
+			return fmt.Errorf("range partitioner with %d partitions needs %d boundaries", n, n-1)
+		}
+		if spec.SampleSize < 0 {
+			return errors.New("range partitioner sample_size must be positive")
+		}
+	case PartitionRoundRobin:
+	case PartitionCustom:
+		if _, ok := customPartitioners[spec.Name]; !ok {
+			return errors.New("unknown custom partitioner: " + spec.Name)
+		}
+	default:
+		return errors.New("unknown partitioner type: " + spec.Type)
+	}
+	return nil
+}
+
+// NeedsSample indica que las boundaries se calculan a partir de una muestra
+// de la salida map-side, así que los buckets se arman recién al leer.
+func (spec *PartitionerSpec) NeedsSample() bool {
+	return spec.Type == PartitionRange && len(spec.Boundaries) == 0
+}
+
+// SampleLimit devuelve cuántas claves muestrea cada tarea map-side.
+func (spec *PartitionerSpec) SampleLimit() int {
+	if spec.SampleSize > 0 {
+		return spec.SampleSize
+	}
+	return defaultSampleSize
+}
+
+// RangeBoundaries elige n-1 límites equiespaciados de la muestra de claves.
+func RangeBoundaries(sample []interface{}, n int) []interface{} {
+	if n <= 1 {
+		return []interface{}{}
+	}
+	keys := append([]interface{}(nil), sample...)
+	sort.Slice(keys, func(i, j int) bool { return CompareKeys(keys[i], keys[j]) < 0 })
+
+	bounds := make([]interface{}, 0, n-1)
+	for i := 1; i < n; i++ {
+		if len(keys) == 0 {
+			// sin muestra: nil es menor que cualquier clave, todo cae en la última partición
+			bounds = append(bounds, nil)
+			continue
+		}
+		bounds = append(bounds, keys[(i*len(keys))/n])
+	}
+	return bounds
+}
+
+// CompareKeys ordena claves: numéricamente si ambas son números, de
+// cualquier tipo (los int64 de un CSV tipado contra los float64 de unas
+// boundaries que pasaron por JSON), si no por su representación como texto.
+// nil va antes que cualquier valor.
+func CompareKeys(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	ia, fa, aint, aok := toNumber(a)
+	ib, fb, bint, bok := toNumber(b)
+	if aok && bok {
+		// dos enteros se comparan como enteros: un float64 pierde precisión
+		// más allá de 2^53
+		if aint && bint {
+			switch {
+			case ia < ib:
+				return -1
+			case ia > ib:
+				return 1
+			}
+			return 0
+		}
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	sa, sb := fmt.Sprint(a), fmt.Sprint(b)
+	switch {
+	case sa < sb:
+		return -1
+	case sa > sb:
+		return 1
+	}
+	return 0
+}
+
+// toNumber lleva un número de cualquier tipo a float64 y, si es entero, a
+// int64. ok es false si v no es un número.
+func toNumber(v interface{}) (i int64, f float64, isInt, ok bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), float64(n), true, true
+	case int32:
+		return int64(n), float64(n), true, true
+	case int64:
+		return n, float64(n), true, true
+	case uint32:
+		return int64(n), float64(n), true, true
+	case float32:
+		return toNumber(float64(n))
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+			return int64(n), n, true, true
+		}
+		return 0, n, false, true
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, float64(i), true, true
+		}
+		if f, err := n.Float64(); err == nil {
+			return toNumber(f)
+		}
+	}
+	return 0, 0, false, false
+}
+
+// HashKey asigna una clave a una de n particiones.
+func HashKey(key interface{}, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	fmt.Fprint(h, key)
+	return int(h.Sum32() % uint32(n))
+}
+
+type hashPartitioner struct {
+	key string
+	n   int
+}
+
+func (p *hashPartitioner) NumPartitions() int { return p.n }
+
+func (p *hashPartitioner) Partition(rec map[string]interface{}) int {
+	return HashKey(rec[p.key], p.n)
+}
+
+type rangePartitioner struct {
+	key    string
+	bounds []interface{}
+}
+
+func (p *rangePartitioner) NumPartitions() int { return len(p.bounds) + 1 }
+
+// Partition devuelve la primera partición cuyo límite superior es >= clave.
+func (p *rangePartitioner) Partition(rec map[string]interface{}) int {
+	k := rec[p.key]
+	return sort.Search(len(p.bounds), func(i int) bool {
+		return CompareKeys(k, p.bounds[i]) <= 0
+	})
+}
+
+type roundRobinPartitioner struct {
+	n    int
+	next int
+}
+
+func (p *roundRobinPartitioner) NumPartitions() int { return p.n }
+
+func (p *roundRobinPartitioner) Partition(map[string]interface{}) int {
+	b := p.next
+	p.next = (p.next + 1) % p.n
+	return b
+}
@@ -0,0 +1,42 @@
+package dag
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompareKeysMixedNumbers(t *testing.T) {
+	cases := []struct {
+		a, b interface{}
+		want int
+	}{
+		{int64(18), float64(100), -1},
+		{float64(100), int64(18), 1},
+		{int64(7), float64(7), 0},
+		{int(3), json.Number("3.5"), -1},
+		{json.Number("20"), int64(9), 1},
+		{int64(1<<62 + 1), int64(1 << 62), 1},
+		{float64(2.5), float64(2.25), 1},
+		{"18", "100", 1}, // texto: orden lexicográfico
+		{nil, int64(0), -1},
+	}
+	for _, c := range cases {
+		if got := CompareKeys(c.a, c.b); got != c.want {
+			t.Errorf("CompareKeys(%#v, %#v) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestRangePartitionerTypedKeys(t *testing.T) {
+	// boundaries como llegan por JSON (float64) y claves de un CSV tipado (int64)
+	spec := &PartitionerSpec{Type: PartitionRange, Key: "age", Boundaries: []interface{}{float64(20), float64(100)}}
+	p, err := NewPartitioner(spec, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for age, want := range map[int64]int{5: 0, 18: 0, 20: 0, 21: 1, 99: 1, 100: 1, 150: 2} {
+		if got := p.Partition(map[string]interface{}{"age": age}); got != want {
+			t.Errorf("age %d: partition %d, want %d", age, got, want)
+		}
+	}
+}
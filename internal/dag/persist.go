@@ -0,0 +1,147 @@
+package dag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Un stage con persist deja la salida de cada partición guardada en el
+// worker que la calculó, bajo una clave que depende de todo lo que la
+// produce (ver CacheKey). Un job posterior que tenga el mismo stage, con los
+// mismos stages antes, lee esas particiones en vez de volver a calcularlas.
+const (
+	// PersistMemory guarda la partición en memoria del worker; se pierde si
+	// el worker reinicia.
+	PersistMemory = "memory"
+	// PersistDisk la guarda en CACHE_DIR, que puede ser un directorio
+	// compartido entre workers.
+	PersistDisk = "disk"
+	// PersistMemoryAndDisk hace las dos cosas: se lee de memoria y, si el
+	// worker reinició, del disco.
+	PersistMemoryAndDisk = "memory_and_disk"
+)
+
+// ValidPersist indica si level es un nivel de persist conocido.
+func ValidPersist(level string) bool {
+	switch level {
+	case PersistMemory, PersistDisk, PersistMemoryAndDisk:
+		return true
+	}
+	return false
+}
+
+// PersistsInMemory y PersistsOnDisk dicen dónde guarda un nivel.
+func PersistsInMemory(level string) bool {
+	return level == PersistMemory || level == PersistMemoryAndDisk
+}
+
+func PersistsOnDisk(level string) bool {
+	return level == PersistDisk || level == PersistMemoryAndDisk
+}
+
+// CacheWrite le indica a una tarea que guarde su salida (antes de
+// repartirla en shuffles) bajo Key con el nivel Level.
+type CacheWrite struct {
+	Key   string `json:"key"`
+	Level string `json:"level"`
+}
+
+// CacheRead le indica a una tarea que tome su salida de la caché en vez de
+// correr el op: la partición guardada bajo Key, que tienen los workers de
+// Hosts (si no la tiene el worker mismo, la trae de alguno).
+type CacheRead struct {
+	Key   string   `json:"key"`
+	Hosts []string `json:"hosts"`
+}
+
+// CachePartition es como se nombra la partición p guardada bajo key cuando
+// un worker informa lo que tiene.
+func CachePartition(key string, p int) string {
+	return key + "/" + strconv.Itoa(p)
+}
+
+// ParseCachePartition separa un nombre de CachePartition.
+func ParseCachePartition(s string) (key string, p int, ok bool) {
+	key, num, ok := strings.Cut(s, "/")
+	if !ok || key == "" {
+		return "", 0, false
+	}
+	p, err := strconv.Atoi(num)
+	return key, p, err == nil && p >= 0
+}
+
+// inputStamp identifica un archivo de entrada tal como está ahora.
+type inputStamp struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+// CacheKey calcula la clave bajo la que se guarda la salida del stage id:
+// un hash de su op, params, particiones y partitioner, de los archivos que
+// lee si es fuente (ruta, tamaño y fecha de modificación) y de las claves de
+// sus dependencias. No depende de los IDs de los stages: dos jobs con la
+// misma cadena de stages sobre los mismos archivos dan la misma clave, y si
+// cambia un archivo de entrada cambia la clave de todo lo que depende de él.
+// Falla si el master no ve los archivos de un stage fuente.
+func (d *DAG) CacheKey(id string) (string, error) {
+	return d.cacheKey(id, map[string]string{})
+}
+
+func (d *DAG) cacheKey(id string, memo map[string]string) (string, error) {
+	if k, ok := memo[id]; ok {
+		return k, nil
+	}
+	st := d.Stages[id]
+	h := struct {
+		Op          string                 `json:"op"`
+		Params      map[string]interface{} `json:"params,omitempty"`
+		Partitions  int                    `json:"partitions"`
+		Partitioner *PartitionerSpec       `json:"partitioner,omitempty"`
+		Inputs      []inputStamp           `json:"inputs,omitempty"`
+		Deps        []string               `json:"deps,omitempty"`
+	}{Op: st.Op, Params: st.Params, Partitions: d.NumPartitions(st), Partitioner: st.Partitioner}
+
+	if IsSourceOp(st.Op) {
+		path, _ := st.Params["path"].(string)
+		files, err := filepath.Glob(path)
+		if err != nil {
+			return "", err
+		}
+		if len(files) == 0 {
+			return "", errors.New("no input files visible on master for " + path)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			fi, err := os.Stat(f)
+			if err != nil {
+				return "", err
+			}
+			h.Inputs = append(h.Inputs, inputStamp{Path: f, Size: fi.Size(), ModTime: fi.ModTime().UnixNano()})
+		}
+	}
+	for _, dep := range st.Dependencies {
+		k, err := d.cacheKey(dep, memo)
+		if err != nil {
+			return "", err
+		}
+		h.Deps = append(h.Deps, k)
+	}
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		return "", fmt.Errorf("stage %s: %w", id, err)
+	}
+	sum := sha256.Sum256(b)
+	key := hex.EncodeToString(sum[:16])
+	memo[id] = key
+	return key, nil
+}
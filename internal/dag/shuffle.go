@@ -0,0 +1,69 @@
+package dag
+
+// Un stage "ancho" (wide) necesita los registros de todas las particiones de
+// sus dependencias repartidos según su particionador (por defecto, hash de
+// la clave). Para eso las tareas de los stages padres (map-side) reparten su
+// salida en buckets que quedan guardados en el worker, y cada tarea del
+// stage ancho (reduce-side) trae su bucket de todos los workers por HTTP.
+
+// wideOps son los operadores que requieren shuffle de su input, con el tipo
+// de particionador que usan si el stage no declara uno.
+var wideOps = map[string]string{
+	"reduce_by_key": PartitionHash,
+	"group_by_key":  PartitionHash,
+	"sort_by_key":   PartitionRange,
+}
+
+// IsWideOp indica si el op necesita un shuffle de su input.
+func IsWideOp(op string) bool {
+	_, ok := wideOps[op]
+	return ok
+}
+
+// IsWide indica si el stage recibe su input a través de un shuffle: por su
+// op o porque declara un partitioner.
+func (s *Stage) IsWide() bool {
+	return IsWideOp(s.Op) || s.Partitioner != nil
+}
+
+// ShuffleKey devuelve el campo por el que el stage agrupa su input.
+func (s *Stage) ShuffleKey() string {
+	k, _ := s.Params["key"].(string)
+	return k
+}
+
+// ShufflePartitioner devuelve el particionador efectivo de un stage ancho:
+// el bloque declarado o el por defecto de su op sobre params.key.
+func (s *Stage) ShufflePartitioner() *PartitionerSpec {
+	if s.Partitioner != nil {
+		return s.Partitioner
+	}
+	return &PartitionerSpec{Type: wideOps[s.Op], Key: s.ShuffleKey()}
+}
+
+// ShuffleWrite le indica a una tarea map-side cómo repartir su salida.
+// Si el particionador necesita muestra (range sin boundaries) la tarea
+// guarda un único bloque y devuelve una muestra de claves; los buckets se
+// arman al leer con las boundaries que calcula el master.
+type ShuffleWrite struct {
+	ShuffleID   string           `json:"shuffle_id"`
+	Buckets     int              `json:"buckets"`
+	Partitioner *PartitionerSpec `json:"partitioner"`
+}
+
+// ShuffleSource es la salida de una tarea map-side y el worker que la guarda.
+type ShuffleSource struct {
+	Host   string `json:"host"`
+	TaskID string `json:"task_id"`
+}
+
+// ShuffleRead le indica a una tarea reduce-side qué bucket leer y de dónde.
+// Partitioner sólo viene cuando los buckets se arman al leer, con las
+// boundaries ya calculadas.
+type ShuffleRead struct {
+	ShuffleID   string           `json:"shuffle_id"`
+	Bucket      int              `json:"bucket"`
+	Buckets     int              `json:"buckets"`
+	Sources     []ShuffleSource  `json:"sources"`
+	Partitioner *PartitionerSpec `json:"partitioner,omitempty"`
+}
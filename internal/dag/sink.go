@@ -0,0 +1,29 @@
+package dag
+
+import "fmt"
+
+// sinkOps son los operadores que escriben su input en archivos bajo
+// params.path, con la extensión de sus part files.
+var sinkOps = map[string]string{
+	"write_csv":   ".csv",
+	"write_jsonl": ".jsonl",
+	"write_text":  ".txt",
+}
+
+const (
+	// SinkTempDir es donde cada intento deja su part file hasta el commit.
+	SinkTempDir = "_temporary"
+	// SuccessFile marca un directorio de salida completo.
+	SuccessFile = "_SUCCESS"
+)
+
+// IsSinkOp indica si el op escribe archivos de salida.
+func IsSinkOp(op string) bool {
+	_, ok := sinkOps[op]
+	return ok
+}
+
+// PartFileName es el nombre final del part file de una partición.
+func PartFileName(op string, partition int) string {
+	return fmt.Sprintf("part-%05d%s", partition, sinkOps[op])
+}
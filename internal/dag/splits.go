@@ -0,0 +1,155 @@
+package dag
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sourceOps son los operadores que leen archivos de entrada; el master les
+// planifica input splits al construir sus tareas.
+var sourceOps = map[string]bool{
+	"read_csv":   true,
+	"read_jsonl": true,
+	"read_text":  true,
+}
+
+// IsSourceOp indica si el op lee archivos de entrada (params.path).
+func IsSourceOp(op string) bool {
+	return sourceOps[op]
+}
+
+const (
+	CompressionNone  = ""
+	CompressionGzip  = "gzip"
+	CompressionBzip2 = "bzip2"
+)
+
+// Compression decide cómo está comprimido un archivo: por params.compression
+// si viene ("gzip", "bzip2", "none"; "auto" o vacío = por extensión) o por la
+// extensión .gz / .bz2.
+func Compression(path, param string) (string, error) {
+	switch strings.ToLower(param) {
+	case "", "auto":
+	case "none":
+		return CompressionNone, nil
+	case "gzip", "gz":
+		return CompressionGzip, nil
+	case "bzip2", "bz2":
+		return CompressionBzip2, nil
+	default:
+		return "", errors.New("unknown compression: " + param)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz":
+		return CompressionGzip, nil
+	case ".bz2":
+		return CompressionBzip2, nil
+	}
+	return CompressionNone, nil
+}
+
+// InputSplit es un rango de bytes de un archivo de entrada. El worker lee
+// las líneas cuyo primer byte cae dentro de [Offset, Offset+Length): si el
+// split empieza a mitad de línea la salta, y si termina a mitad de línea la
+// completa leyendo más allá del final. Un archivo comprimido no se puede
+// partir: va entero en un solo split con su Compression.
+type InputSplit struct {
+	Path        string `json:"path"`
+	Offset      int64  `json:"offset"`
+	Length      int64  `json:"length"`
+	Compression string `json:"compression,omitempty"`
+}
+
+// PlanSplits reparte los archivos que matchean glob en parts particiones.
+// Los archivos sin comprimir se cortan en rangos contiguos de tamaño
+// similar, como si estuvieran concatenados; los comprimidos van enteros a la
+// partición con menos bytes asignados. Devuelve un slice de splits por
+// partición (puede haber particiones vacías) o nil si no hay archivos.
+// compression es el params.compression del stage.
+func PlanSplits(glob string, parts int, compression string) ([][]InputSplit, error) {
+	files, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	type entry struct {
+		path  string
+		size  int64
+		codec string
+	}
+	var plain, compressed []entry
+	var total int64
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() || fi.Size() == 0 {
+			continue
+		}
+		codec, err := Compression(f, compression)
+		if err != nil {
+			return nil, err
+		}
+		e := entry{f, fi.Size(), codec}
+		if codec != CompressionNone {
+			compressed = append(compressed, e)
+			continue
+		}
+		plain = append(plain, e)
+		total += fi.Size()
+	}
+	if len(plain) == 0 && len(compressed) == 0 {
+		return nil, nil
+	}
+	if parts <= 0 {
+		parts = 1
+	}
+
+	out := make([][]InputSplit, parts)
+	load := make([]int64, parts)
+	var base int64 // offset global del inicio del archivo actual
+	for _, e := range plain {
+		for p := 0; p < parts; p++ {
+			// rango global [lo, hi) de la partición p
+			lo := total * int64(p) / int64(parts)
+			hi := total * int64(p+1) / int64(parts)
+			// intersección con el archivo [base, base+size)
+			start := max(lo, base)
+			end := min(hi, base+e.size)
+			if start >= end {
+				continue
+			}
+			out[p] = append(out[p], InputSplit{
+				Path:   e.path,
+				Offset: start - base,
+				Length: end - start,
+			})
+			load[p] += end - start
+		}
+		base += e.size
+	}
+
+	// comprimidos: el más grande primero, a la partición menos cargada
+	sort.SliceStable(compressed, func(i, j int) bool { return compressed[i].size > compressed[j].size })
+	for _, e := range compressed {
+		p := 0
+		for q := range load {
+			if load[q] < load[p] {
+				p = q
+			}
+		}
+		out[p] = append(out[p], InputSplit{
+			Path:        e.path,
+			Offset:      0,
+			Length:      e.size,
+			Compression: e.codec,
+		})
+		load[p] += e.size
+	}
+	return out, nil
+}
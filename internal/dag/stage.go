@@ -1,11 +1,57 @@
 package dag
 
+import "time"
+
 // Stage representa una etapa lógica del DAG.
 // Opciones típicas: id, op (map/filter/...), parametros y dependencias.
+// Partitioner (opcional) decide cómo se reparten las particiones del stage
+// y hace que reciba su input por shuffle. Timeout (opcional, p.ej. "90s")
+// es cuánto puede tardar cada intento de tarea antes de que el master lo
+// corte y lo reintente; sin él vale el del master (TASK_TIMEOUT). Resources (opcional) es lo que necesita cada tarea
+// del stage para que el master la mande a un worker. Persist (opcional:
+// memory, disk o memory_and_disk) guarda la salida del stage para que otros
+// jobs la reusen (ver persist.go).
 type Stage struct {
 	ID           string                 `json:"id"`
 	Op           string                 `json:"op,omitempty"`
 	Params       map[string]interface{} `json:"params,omitempty"`
 	Partitions   int                    `json:"partitions,omitempty"`
+	Partitioner  *PartitionerSpec       `json:"partitioner,omitempty"`
 	Dependencies []string               `json:"dependencies,omitempty"`
+	Timeout      string                 `json:"timeout,omitempty"`
+	Resources    *Resources             `json:"resources,omitempty"`
+	Persist      string                 `json:"persist,omitempty"`
+}
+
+// Resources es lo que pide cada tarea de un stage: sólo va a un worker que
+// tenga declaradas esa memoria y esas CPUs libres, descontando las de sus
+// otras tareas. Si ningún worker registrado las tiene en total, la tarea
+// falla en vez de esperar para siempre.
+type Resources struct {
+	MemoryMB int64 `json:"memory_mb,omitempty"`
+	CPUs     int   `json:"cpus,omitempty"`
+}
+
+// TaskTimeout devuelve el timeout por intento del stage, 0 si no tiene.
+func (s *Stage) TaskTimeout() time.Duration {
+	d, _ := time.ParseDuration(s.Timeout)
+	return d
+}
+
+// TaskMemoryMB devuelve la memoria que pide cada tarea del stage, 0 si no
+// declara resources.
+func (s *Stage) TaskMemoryMB() int64 {
+	if s.Resources == nil {
+		return 0
+	}
+	return s.Resources.MemoryMB
+}
+
+// TaskCPUs devuelve las CPUs que pide cada tarea del stage, 0 si no
+// declara resources.
+func (s *Stage) TaskCPUs() int {
+	if s.Resources == nil {
+		return 0
+	}
+	return s.Resources.CPUs
 }
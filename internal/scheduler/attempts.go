@@ -0,0 +1,234 @@
+package scheduler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"batchdag/internal/core"
+)
+
+// startAttempt le da a t un ID de intento nuevo y lo registra en el
+// JobManager como intento vigente de la tarea, justo antes de despacharla:
+// sólo el intento con ese ID puede completarla (ver JobManager.CompleteTask),
+// así el resultado tardío de un intento vencido o reemplazado no pisa el de
+// otro. Devuelve false si la tarea ya no hace falta.
+func (s *Scheduler) startAttempt(t *TaskSpec) bool {
+	t.AttemptID = newAttemptID(t)
+	return s.jm.StartAttempt(t.JobID, t.TaskID, t.AttemptID)
+}
+
+// newAttemptID arma un ID único para un intento de t: el número de intento
+// más un sufijo al azar, que distingue reintentos y copias especulativas con
+// el mismo número.
+func newAttemptID(t *TaskSpec) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("a%d-%s", t.Attempts, hex.EncodeToString(b))
+}
+
+// runningTask es un intento despachado a un worker. Está vigente hasta que
+// el worker informa cómo terminó (HandleReport), vence su timeout o se
+// abandona (worker caído, shuffle perdido, job cancelado, ganó otro intento
+// de la misma tarea); lo que llegue después de un intento que ya no está en
+// running se descarta. Una tarea puede tener a la vez su intento y una
+// copia especulativa (ver speculation.go), cada una con su TaskSpec.
+type runningTask struct {
+	jobID    string
+	worker   *core.WorkerInfo
+	spec     *TaskSpec
+	timer    *time.Timer
+	lease    *time.Timer // sólo workers en pull
+	locality string
+	started  time.Time
+}
+
+// release saca de running el intento attemptID, si sigue vigente, y libera
+// su lugar en el worker.
+func (s *Scheduler) release(attemptID string) (*runningTask, bool) {
+	s.mu.Lock()
+	rt, ok := s.running[attemptID]
+	if !ok {
+		s.mu.Unlock()
+		return nil, false
+	}
+	s.releaseLocked(rt)
+	s.mu.Unlock()
+
+	s.queue.Wake()
+	return rt, true
+}
+
+// releaseWhere libera todos los intentos en curso que cumplen match.
+func (s *Scheduler) releaseWhere(match func(rt *runningTask) bool) []*runningTask {
+	s.mu.Lock()
+	var out []*runningTask
+	for _, rt := range s.running {
+		if match(rt) {
+			s.releaseLocked(rt)
+			out = append(out, rt)
+		}
+	}
+	s.mu.Unlock()
+
+	s.queue.Wake()
+	return out
+}
+
+// releaseLocked no despierta a los que esperan lugar en un worker (ver
+// TaskQueue.Wake): eso lo hacen release y releaseWhere ya sin s.mu.
+func (s *Scheduler) releaseLocked(rt *runningTask) {
+	delete(s.running, rt.spec.AttemptID)
+	if rt.timer != nil {
+		rt.timer.Stop()
+	}
+	if rt.lease != nil {
+		rt.lease.Stop()
+	}
+	if s.activeTasks[rt.worker.ID] > 0 {
+		s.activeTasks[rt.worker.ID]--
+	}
+	s.reservedMem[rt.worker.ID] = max(s.reservedMem[rt.worker.ID]-rt.spec.MemoryMB, 0)
+	s.reservedCPU[rt.worker.ID] = max(s.reservedCPU[rt.worker.ID]-rt.spec.CPUs, 0)
+}
+
+// attemptsLocked devuelve los intentos en curso de la tarea taskID.
+func (s *Scheduler) attemptsLocked(taskID string) []*runningTask {
+	var out []*runningTask
+	for _, rt := range s.running {
+		if rt.spec.TaskID == taskID {
+			out = append(out, rt)
+		}
+	}
+	return out
+}
+
+// stillRunning indica si a la tarea le queda algún intento en curso.
+func (s *Scheduler) stillRunning(taskID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.attemptsLocked(taskID)) > 0
+}
+
+// HandleReport aplica el resultado que informa un worker (POST
+// /tasks/report). Devuelve false si el reporte no corresponde a un intento
+// vigente de la tarea. El primer intento que termina bien gana y los demás
+// se cortan.
+func (s *Scheduler) HandleReport(rep *core.TaskReport) bool {
+	var rt *runningTask
+	s.mu.Lock()
+	for _, a := range s.attemptsLocked(rep.TaskID) {
+		if a.jobID == rep.JobID && a.worker.ID == rep.WorkerID && a.spec.AttemptID == rep.AttemptID {
+			rt = a
+		}
+	}
+	s.mu.Unlock()
+	if rt == nil {
+		log.Printf("Dropping stale report for task %s from %s (attempt %s)\n", rep.TaskID, rep.WorkerID, rep.AttemptID)
+		return false
+	}
+	if _, ok := s.release(rt.spec.AttemptID); !ok {
+		return false
+	}
+	t, worker := rt.spec, rt.worker
+
+	if rep.Status != "ok" {
+		log.Printf("Task %s failed on %s: %s %s\n", t.TaskID, worker.ID, rep.Status, rep.Error)
+		if rep.CacheMiss && s.cacheMiss(rt, rep.Error) {
+			return true
+		}
+		s.handleFailure(rt, rep.Error, rep.Infra)
+		return true
+	}
+
+	// save results into job manager (store raw JSON-serializable output);
+	// sólo si este intento sigue siendo el que puede completar la tarea
+	ok := s.jm.CompleteTask(t.JobID, t.TaskID, t.AttemptID, func(jt *core.JobTask) {
+		if len(rep.Output) > 0 {
+			jt.Result = rep.Output
+		}
+		jt.KeySamples = rep.Samples
+		jt.Persisted = rep.Persisted
+		jt.AssignedTo = worker.ID
+		jt.OutputHost = worker.Host
+		jt.Locality = rt.locality
+		jt.History = append(jt.History, rt.record("DONE", ""))
+	})
+	if !ok {
+		return true
+	}
+	s.recordDuration(rt)
+
+	log.Printf("Task %s completed on worker %s (%s)\n", t.TaskID, worker.ID, rt.locality)
+	s.cancelOtherAttempts(t.JobID, t.TaskID)
+	return true
+}
+
+// armTimeoutLocked arranca el reloj del timeout del stage o, si no tiene,
+// el de TaskTimeout. El reloj es del intento, no de la tarea: si vence
+// después de que el intento terminó, no toca al que lo reemplazó.
+func (s *Scheduler) armTimeoutLocked(rt *runningTask) {
+	d := rt.spec.Timeout
+	if d <= 0 {
+		d = s.TaskTimeout
+	}
+	if d <= 0 {
+		return
+	}
+	worker, attemptID := rt.worker, rt.spec.AttemptID
+	rt.timer = time.AfterFunc(d, func() { s.onTimeout(worker, attemptID, d) })
+}
+
+// onTimeout corta el intento attemptID, que superó su timeout, y lo cuenta
+// como un intento fallido. No hace nada si el intento ya no está en curso.
+func (s *Scheduler) onTimeout(worker *core.WorkerInfo, attemptID string, d time.Duration) {
+	rt, ok := s.release(attemptID)
+	if !ok {
+		return
+	}
+	t := rt.spec
+	log.Printf("Task %s timed out on %s after %s\n", t.TaskID, worker.ID, d)
+	s.cancelOnWorker(worker, t.JobID, t.TaskID)
+	s.handleFailure(rt, fmt.Sprintf("timed out after %s", d), true)
+}
+
+// record arma la entrada del historial de la tarea para este intento.
+func (rt *runningTask) record(status, errMsg string) core.TaskAttempt {
+	return core.TaskAttempt{
+		Attempt:     rt.spec.Attempts,
+		AttemptID:   rt.spec.AttemptID,
+		Worker:      rt.worker.ID,
+		Status:      status,
+		Error:       errMsg,
+		DurationMs:  time.Since(rt.started).Milliseconds(),
+		Speculative: rt.spec.speculative,
+	}
+}
+
+// recordAttempt da por terminado sin resultado (fallido, perdido o
+// cancelado) un intento de la tarea: deja de poder completarla y queda en su
+// historial.
+func (s *Scheduler) recordAttempt(rt *runningTask, status, errMsg string) {
+	rec := rt.record(status, errMsg)
+	s.jm.EndAttempt(rt.jobID, rt.spec.TaskID, rt.spec.AttemptID, &rec)
+}
+
+// cancelOnWorker pide al worker que corte la tarea taskID o, si viene
+// vacía, todas las del job (POST /task/cancel).
+func (s *Scheduler) cancelOnWorker(worker *core.WorkerInfo, jobID, taskID string) {
+	target := "job " + jobID
+	if taskID != "" {
+		target = "task " + taskID
+	}
+	b, _ := json.Marshal(map[string]string{"job_id": jobID, "task_id": taskID})
+	resp, err := s.client.Post(worker.Host+"/task/cancel", "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Printf("Cancel of %s on %s failed: %v\n", target, worker.ID, err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,125 @@
+package scheduler
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"batchdag/internal/core"
+	"batchdag/internal/dag"
+)
+
+// runningJob arma un scheduler con un job ya construido de parts tareas y
+// devuelve el ID de la primera.
+func runningJob(t *testing.T, parts int) (*Scheduler, *core.JobManager, string) {
+	t.Helper()
+	d := dag.New()
+	d.AddStage(&dag.Stage{ID: "s1", Op: "map", Params: map[string]interface{}{"fn": "to_lower"}, Partitions: parts})
+	job := &core.Job{ID: "job-1", DAG: d, State: core.JobAccepted, CreatedAt: time.Now(), Priority: 1}
+	jm := core.NewJobManager()
+	jm.Add(job)
+	as := jm.BuildTasks(job)
+	if len(as) != parts {
+		t.Fatalf("built %d tasks, want %d", len(as), parts)
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].Partition < as[j].Partition })
+	return NewScheduler(core.NewWorkerRegistry(), jm, NewTaskQueue()), jm, as[0].TaskID
+}
+
+// dispatch registra un intento de la tarea en w como si se hubiera despachado.
+func dispatch(t *testing.T, s *Scheduler, taskID string, w *core.WorkerInfo) *TaskSpec {
+	t.Helper()
+	spec := &TaskSpec{JobID: "job-1", TaskID: taskID}
+	if !s.startAttempt(spec) {
+		t.Fatalf("task %s is not runnable", taskID)
+	}
+	s.mu.Lock()
+	s.running[spec.AttemptID] = &runningTask{jobID: spec.JobID, worker: w, spec: spec, started: time.Now()}
+	s.mu.Unlock()
+	return spec
+}
+
+func TestReportOfStaleAttemptIsDropped(t *testing.T) {
+	s, jm, taskID := runningJob(t, 1)
+	w := &core.WorkerInfo{ID: "w1"}
+
+	// el primer intento se perdió (venció su lease) y se relanzó
+	stale := dispatch(t, s, taskID, w)
+	if rt, ok := s.release(stale.AttemptID); ok {
+		s.recordAttempt(rt, "LOST", "lease expired")
+	}
+	fresh := dispatch(t, s, taskID, w)
+
+	report := func(attemptID string) bool {
+		return s.HandleReport(&core.TaskReport{
+			JobID: "job-1", TaskID: taskID, WorkerID: w.ID, AttemptID: attemptID,
+			Status: "ok", Output: []interface{}{attemptID},
+		})
+	}
+	if report(stale.AttemptID) {
+		t.Errorf("report of the lost attempt was accepted")
+	}
+	if j, _ := jm.Get("job-1"); j.Tasks[taskID].Status == "DONE" {
+		t.Fatalf("the lost attempt completed the task")
+	}
+
+	if !report(fresh.AttemptID) {
+		t.Fatalf("report of the current attempt was dropped")
+	}
+	j, _ := jm.Get("job-1")
+	if task := j.Tasks[taskID]; task.Status != "DONE" || task.AttemptID != fresh.AttemptID {
+		t.Errorf("task = %s by attempt %s, want DONE by %s", task.Status, task.AttemptID, fresh.AttemptID)
+	}
+}
+
+func TestCompleteTaskIgnoresEndedAttempt(t *testing.T) {
+	s, jm, taskID := runningJob(t, 1)
+	w := &core.WorkerInfo{ID: "w1"}
+
+	// el scheduler todavía tiene el intento, pero el JobManager ya lo dio
+	// por terminado (p.ej. un reporte tardío que se cruzó con el reintento)
+	spec := dispatch(t, s, taskID, w)
+	jm.EndAttempt("job-1", taskID, spec.AttemptID, nil)
+
+	s.HandleReport(&core.TaskReport{
+		JobID: "job-1", TaskID: taskID, WorkerID: w.ID, AttemptID: spec.AttemptID, Status: "ok",
+	})
+	if j, _ := jm.Get("job-1"); j.Tasks[taskID].Status == "DONE" {
+		t.Errorf("an attempt that was no longer live completed the task")
+	}
+}
+
+func TestOldTimersDoNotTouchTheRetry(t *testing.T) {
+	s, jm, taskID := runningJob(t, 1)
+	w := &core.WorkerInfo{ID: "w1"}
+
+	first := dispatch(t, s, taskID, w)
+	rt, _ := s.release(first.AttemptID)
+	s.handleFailure(rt, "boom", false)
+
+	// el reintento es otro TaskSpec y se despacha como un intento nuevo
+	retry := s.queue.TakeTask(taskID)
+	if retry == nil || retry == first {
+		t.Fatalf("the retry was not queued as a new spec")
+	}
+	if !s.startAttempt(retry) {
+		t.Fatalf("the retry is not runnable")
+	}
+	s.mu.Lock()
+	s.running[retry.AttemptID] = &runningTask{jobID: retry.JobID, worker: w, spec: retry, started: time.Now()}
+	s.mu.Unlock()
+
+	// vencen los relojes que había armado el primer intento
+	s.onTimeout(w, first.AttemptID, time.Second)
+	s.onLeaseExpired(w, first.AttemptID)
+
+	s.mu.Lock()
+	_, running := s.running[retry.AttemptID]
+	s.mu.Unlock()
+	if !running {
+		t.Errorf("a timer of the first attempt released the retry")
+	}
+	if j, _ := jm.Get("job-1"); j.Tasks[taskID].Attempts != 1 {
+		t.Errorf("task counted %d attempts, want 1", j.Tasks[taskID].Attempts)
+	}
+}
@@ -0,0 +1,125 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+)
+
+// BlacklistConfig controla la exclusión de workers que fallan seguido: con
+// MaxFailuresPerJob fallos de tareas de un job en un worker, el worker no
+// recibe más tareas de ese job; si queda excluido así en MaxJobs jobs
+// distintos dentro de un Timeout, aunque esos jobs ya hayan terminado, no
+// recibe tareas de ningún job. Cada exclusión vence a los Timeout. Las exclusiones nunca dejan a un job sin workers: si todos los
+// workers UP están excluidos, se ignoran.
+type BlacklistConfig struct {
+	MaxFailuresPerJob int
+	MaxJobs           int
+	Timeout           time.Duration
+}
+
+// DefaultBlacklist son los límites por defecto.
+var DefaultBlacklist = BlacklistConfig{
+	MaxFailuresPerJob: 2,
+	MaxJobs:           2,
+	Timeout:           5 * time.Minute,
+}
+
+// blacklist guarda las exclusiones vigentes. Se usa con s.mu tomado.
+type blacklist struct {
+	failures map[string]map[string]int       // jobID -> workerID -> fallos
+	jobs     map[string]map[string]time.Time // jobID -> workerID -> excluido hasta
+	cluster  map[string]time.Time            // workerID -> excluido hasta
+	// failedJobs recuerda en qué jobs quedó excluido cada worker, para la
+	// exclusión de todo el cluster: no se borra cuando el job termina, sino
+	// cuando vence (workerID -> jobID -> cuenta hasta)
+	failedJobs map[string]map[string]time.Time
+}
+
+func newBlacklist() *blacklist {
+	return &blacklist{
+		failures: make(map[string]map[string]int),
+		jobs:     make(map[string]map[string]time.Time),
+		cluster:  make(map[string]time.Time),
+
+		failedJobs: make(map[string]map[string]time.Time),
+	}
+}
+
+// retryBackoff es cuánto espera en la cola el reintento número attempts:
+// 500ms, 1s, 2s... hasta 30s.
+func retryBackoff(attempts int) time.Duration {
+	d := 500 * time.Millisecond << max(attempts-1, 0)
+	return min(d, 30*time.Second)
+}
+
+// noteFailure cuenta un fallo de una tarea del job en el worker y lo
+// excluye si llegó al límite. Sólo cuentan los fallos del worker o de la
+// red: se perdió el worker o el lease, venció el timeout, no se pudo
+// despachar o falló una lectura o escritura de shuffle o de caché. Un error
+// del código de la tarea fallaría igual en cualquier worker.
+func (s *Scheduler) noteFailure(jobID, workerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bl, cfg := s.blacklist, s.Blacklist
+	now := time.Now()
+
+	if bl.failures[jobID] == nil {
+		bl.failures[jobID] = make(map[string]int)
+	}
+	bl.failures[jobID][workerID]++
+	if bl.failures[jobID][workerID] < cfg.MaxFailuresPerJob {
+		return
+	}
+	delete(bl.failures[jobID], workerID)
+
+	until := now.Add(cfg.Timeout)
+	if bl.jobs[jobID] == nil {
+		bl.jobs[jobID] = make(map[string]time.Time)
+	}
+	bl.jobs[jobID][workerID] = until
+	log.Printf("Worker %s blacklisted for job %s until %s\n", workerID, jobID, until.Format(time.TimeOnly))
+
+	if bl.failedJobs[workerID] == nil {
+		bl.failedJobs[workerID] = make(map[string]time.Time)
+	}
+	failed := bl.failedJobs[workerID]
+	failed[jobID] = until
+	for id, t := range failed {
+		if !now.Before(t) {
+			delete(failed, id)
+		}
+	}
+	if jobs := len(failed); jobs >= cfg.MaxJobs {
+		bl.cluster[workerID] = until
+		log.Printf("Worker %s blacklisted for all jobs until %s (%d jobs)\n", workerID, until.Format(time.TimeOnly), jobs)
+	}
+}
+
+// usableLocked indica si w puede recibir tareas del job: no está excluido
+// para el job ni para todo el cluster. Las exclusiones vencidas se borran.
+func (s *Scheduler) usableLocked(jobID, workerID string, now time.Time) bool {
+	bl := s.blacklist
+	if until, ok := bl.cluster[workerID]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(bl.cluster, workerID)
+		log.Printf("Worker %s is no longer blacklisted\n", workerID)
+	}
+	if until, ok := bl.jobs[jobID][workerID]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(bl.jobs[jobID], workerID)
+		log.Printf("Worker %s is no longer blacklisted for job %s\n", workerID, jobID)
+	}
+	return true
+}
+
+// forgetJobBlacklistLocked borra las exclusiones de un job que terminó o se
+// canceló. Sigue contando para excluir al worker de todo el cluster (ver
+// failedJobs).
+func (s *Scheduler) forgetJobBlacklistLocked(jobID string) {
+	delete(s.blacklist.failures, jobID)
+	delete(s.blacklist.jobs, jobID)
+}
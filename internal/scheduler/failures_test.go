@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"batchdag/internal/core"
+)
+
+// failingScheduler arma un scheduler con un job en curso de una tarea.
+func failingScheduler() *Scheduler {
+	jm := core.NewJobManager()
+	jm.Add(&core.Job{
+		ID:     "job-1",
+		State:  core.JobRunning,
+		Tasks:  map[string]*core.JobTask{"job-1-s1-p0": {ID: "job-1-s1-p0", StageID: "s1"}},
+		Stages: map[string]*core.StageStatus{"s1": {State: core.StageRunning}},
+	})
+	s := NewScheduler(core.NewWorkerRegistry(), jm, NewTaskQueue())
+	s.Blacklist.MaxFailuresPerJob = 1
+	return s
+}
+
+func TestOnlyInfraFailuresBlacklist(t *testing.T) {
+	w := &core.WorkerInfo{ID: "w1"}
+	for _, infra := range []bool{false, true} {
+		s := failingScheduler()
+		rt := &runningTask{jobID: "job-1", worker: w, spec: &TaskSpec{JobID: "job-1", TaskID: "job-1-s1-p0"}}
+		s.handleFailure(rt, "boom", infra)
+
+		_, excluded := s.blacklist.jobs["job-1"]["w1"]
+		if excluded != infra {
+			t.Errorf("infra=%v: worker blacklisted = %v, want %v", infra, excluded, infra)
+		}
+	}
+}
+
+func TestJobFinishedForgetsBlacklist(t *testing.T) {
+	s := failingScheduler()
+	s.noteFailure("job-1", "w1")
+	s.noteFailure("job-2", "w1")
+
+	s.JobFinished("job-1")
+
+	if _, ok := s.blacklist.jobs["job-1"]; ok {
+		t.Errorf("exclusions of a finished job were kept")
+	}
+	if _, ok := s.blacklist.jobs["job-2"]["w1"]; !ok {
+		t.Errorf("exclusions of another job were dropped")
+	}
+}
+
+func TestClusterBlacklistCountsFinishedJobs(t *testing.T) {
+	cases := []struct {
+		name    string
+		between func(s *Scheduler) // entre el fallo en job-1 y el de job-2
+		banned  bool
+	}{
+		{"jobs at the same time", func(*Scheduler) {}, true},
+		{"first job finished", func(s *Scheduler) { s.JobFinished("job-1") }, true},
+		{"first exclusion expired", func(s *Scheduler) {
+			s.blacklist.failedJobs["w1"]["job-1"] = time.Now().Add(-time.Second)
+		}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := failingScheduler()
+			s.noteFailure("job-1", "w1")
+			tc.between(s)
+			s.noteFailure("job-2", "w1")
+
+			if _, banned := s.blacklist.cluster["w1"]; banned != tc.banned {
+				t.Errorf("worker blacklisted for all jobs = %v, want %v", banned, tc.banned)
+			}
+		})
+	}
+}
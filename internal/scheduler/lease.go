@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"batchdag/internal/core"
+)
+
+// leaseTTL es cuánto dura un lease sin renovar. Los workers en pull lo
+// renuevan con cada heartbeat (cada 2s), así que sólo vence si el worker
+// dejó de responder.
+const leaseTTL = 10 * time.Second
+
+// Lease entrega hasta slots tareas de la cola a un worker en modo pull,
+// esperando a lo sumo wait a que haya alguna (long-poll). Igual que en push,
+// sólo le entrega tareas que entren en los slots, la memoria y las CPUs
+// libres que el master cuenta para ese worker y que puedan correr ahí según su localidad.
+// Cada tarea queda en running como un intento más, con un lease que hay que
+// renovar; el resultado llega por HandleReport igual que en push. Si el
+// worker corta el pedido (ctx) antes de la respuesta, las tareas vuelven a
+// la cola.
+func (s *Scheduler) Lease(ctx context.Context, workerID string, slots int, wait time.Duration) []core.TaskLease {
+	worker, ok := s.registry.Get(workerID)
+	if !ok || worker.State != core.WorkerUp || worker.Restored() || slots <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(wait)
+	var out []core.TaskLease
+	var leased []*runningTask
+	for len(out) == 0 && ctx.Err() == nil {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		// el lugar libre se vuelve a mirar en cada pasada (ver capacity):
+		// mientras el lease espera pueden terminar otras tareas del worker
+		levels := map[*TaskSpec]string{}
+		pass := func() func(t *TaskSpec) bool {
+			c := s.snapshot(func(w *core.WorkerInfo) bool { return w.ID == workerID })
+			return func(t *TaskSpec) bool {
+				w, level := s.pickWorker(c, t)
+				if w == nil {
+					return false
+				}
+				c.take(w, t)
+				levels[t] = level
+				return true
+			}
+		}
+		for _, t := range s.queue.PopWait(ctx, slots, remaining, pass) {
+			if !s.startAttempt(t) {
+				continue
+			}
+			b, _ := json.Marshal(payloadFor(t))
+
+			rt := &runningTask{jobID: t.JobID, worker: worker, spec: t, locality: levels[t], started: time.Now()}
+			s.mu.Lock()
+			s.reserveLocked(worker, t)
+			s.running[t.AttemptID] = rt
+			s.armLeaseLocked(rt)
+			s.armTimeoutLocked(rt)
+			s.mu.Unlock()
+
+			out = append(out, core.TaskLease{Task: b, ExpiresAt: time.Now().Add(leaseTTL)})
+			leased = append(leased, rt)
+		}
+	}
+
+	if ctx.Err() != nil {
+		for _, rt := range leased {
+			if _, ok := s.release(rt.spec.AttemptID); ok {
+				s.jm.EndAttempt(rt.jobID, rt.spec.TaskID, rt.spec.AttemptID, nil)
+				// una copia, como al reintentar (ver retryAttempt)
+				again := *rt.spec
+				s.queue.Push(&again)
+			}
+		}
+		return nil
+	}
+	for _, rt := range leased {
+		log.Printf("Task %s leased to worker %s (%s)\n", rt.spec.TaskID, worker.ID, rt.locality)
+	}
+	return out
+}
+
+// RenewLeases extiende los leases que el worker dice tener (heartbeat).
+func (s *Scheduler) RenewLeases(workerID string, taskIDs []string) {
+	ids := make(map[string]bool, len(taskIDs))
+	for _, id := range taskIDs {
+		ids[id] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rt := range s.running {
+		if ids[rt.spec.TaskID] && rt.worker.ID == workerID && rt.lease != nil {
+			rt.lease.Reset(leaseTTL)
+		}
+	}
+}
+
+// armLeaseLocked arranca el lease del intento rt, de un worker en pull.
+func (s *Scheduler) armLeaseLocked(rt *runningTask) {
+	worker, attemptID := rt.worker, rt.spec.AttemptID
+	rt.lease = time.AfterFunc(leaseTTL, func() { s.onLeaseExpired(worker, attemptID) })
+}
+
+// onLeaseExpired reintenta una tarea cuyo worker dejó de renovar el lease
+// del intento attemptID; si después reporta, el reporte se descarta. Cuenta
+// como un intento fallido (ver handleFailure): una tarea que siempre pierde
+// el lease (p.ej. porque tumba al worker) no se reencola para siempre. No
+// hace nada si el intento ya no está en curso.
+func (s *Scheduler) onLeaseExpired(worker *core.WorkerInfo, attemptID string) {
+	rt, ok := s.release(attemptID)
+	if !ok {
+		return
+	}
+	log.Printf("Lease of task %s on %s expired, requeueing\n", rt.spec.TaskID, worker.ID)
+	s.retryAttempt(rt, "LOST", "lease expired", true)
+}
@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"time"
+
+	"batchdag/internal/core"
+)
+
+// locality decide si t puede correr en w y con qué nivel de localidad. En
+// un worker preferido (los que ya guardan su input) siempre; en otro sólo si
+// t no tiene preferidos, si ya esperó localityWait desde que se encoló o si
+// ninguno de sus preferidos está UP (workers es la lista del registry).
+func (s *Scheduler) locality(t *TaskSpec, w *core.WorkerInfo, workers []*core.WorkerInfo) (string, bool) {
+	if len(t.Preferred) == 0 {
+		return core.LocalityNoPref, true
+	}
+	for _, id := range t.Preferred {
+		if id == w.ID {
+			return core.LocalityNode, true
+		}
+	}
+	if time.Since(t.queuedAt) >= s.localityWait || !preferredUp(t, workers) {
+		return core.LocalityAny, true
+	}
+	return "", false
+}
+
+func preferredUp(t *TaskSpec, workers []*core.WorkerInfo) bool {
+	for _, w := range workers {
+		if w.State != core.WorkerUp {
+			continue
+		}
+		for _, id := range t.Preferred {
+			if id == w.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
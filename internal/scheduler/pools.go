@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+)
+
+// DefaultPool es el pool de los jobs que no piden otro.
+const DefaultPool = "default"
+
+// Pool agrupa jobs que comparten una parte del cluster. Entre pools se
+// reparte por Weight; un pool que corre menos de MinShare tareas pasa
+// antes que los que ya tienen su mínimo. Los pools que no están
+// configurados tienen peso 1 y sin mínimo.
+type Pool struct {
+	Weight   int `json:"weight"`
+	MinShare int `json:"min_share"`
+}
+
+// Usage es lo que está corriendo en el cluster, por job y por pool.
+type Usage struct {
+	Jobs  map[string]int
+	Pools map[string]int
+}
+
+// ParsePools lee la configuración de pools en JSON, p.ej.
+// {"interactive": {"weight": 2, "min_share": 4}}.
+func ParsePools(b []byte) (map[string]Pool, error) {
+	var pools map[string]Pool
+	if err := json.Unmarshal(b, &pools); err != nil {
+		return nil, err
+	}
+	for name, p := range pools {
+		if p.Weight < 0 || p.MinShare < 0 {
+			return nil, fmt.Errorf("pool %s: weight and min_share must not be negative", name)
+		}
+	}
+	return pools, nil
+}
+
+// SetPools reemplaza la configuración de pools.
+func (q *TaskQueue) SetPools(pools map[string]Pool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pools = make(map[string]Pool, len(pools))
+	for name, p := range pools {
+		q.pools[name] = p
+	}
+}
+
+func poolName(name string) string {
+	if name == "" {
+		return DefaultPool
+	}
+	return name
+}
+
+func (q *TaskQueue) usageLocked() Usage {
+	if q.usageFn != nil {
+		return q.usageFn()
+	}
+	return Usage{Jobs: map[string]int{}, Pools: map[string]int{}}
+}
+
+// fairOrderLocked arma el orden en que les toca sacar tareas a los jobs con
+// tareas pendientes: primero los pools por debajo de su MinShare (el más
+// lejos de su mínimo antes), después el resto de los pools por tareas en
+// curso sobre Weight; dentro de un pool, los jobs por tareas en curso sobre
+// priority y, a igualdad, el que llegó antes. Como cada tarea que sale
+// cuenta como en curso (ver fairOrder.took), sacar de a una reparte como un
+// round-robin pesado.
+func (q *TaskQueue) fairOrderLocked(usage Usage) *fairOrder {
+	byPool := map[string]*poolTurn{}
+	o := &fairOrder{}
+	for _, jq := range q.jobs {
+		p, ok := byPool[jq.pool]
+		if !ok {
+			p = &poolTurn{name: jq.pool, cfg: q.pools[jq.pool], running: usage.Pools[jq.pool]}
+			byPool[jq.pool] = p
+			o.pools = append(o.pools, p)
+		}
+		p.jobs = append(p.jobs, &jobTurn{q: jq, running: usage.Jobs[jq.jobID]})
+	}
+	for _, p := range o.pools {
+		heap.Init(&p.jobs)
+	}
+	heap.Init(&o.pools)
+	return o
+}
+
+// fairOrder es el orden de fair share de una pasada por la cola: un heap de
+// pools y, en cada pool, uno de jobs. Sacar una tarea sólo reacomoda su job
+// y su pool, en vez de volver a ordenar todos los jobs.
+type fairOrder struct {
+	pools poolHeap
+}
+
+// next devuelve el job al que le toca, o nil si no queda ninguno.
+func (o *fairOrder) next() *jobQueue {
+	if len(o.pools) == 0 {
+		return nil
+	}
+	return o.pools[0].jobs[0].q
+}
+
+// took anota que el job de next sacó una tarea; done indica que no le
+// quedan más y sale del orden.
+func (o *fairOrder) took(done bool) {
+	p := o.pools[0]
+	p.running++
+	p.jobs[0].running++
+	if done {
+		heap.Pop(&p.jobs)
+	} else {
+		heap.Fix(&p.jobs, 0)
+	}
+	o.fixPool()
+}
+
+// drop saca del orden al job de next: en esta pasada no va a sacar más
+// tareas.
+func (o *fairOrder) drop() {
+	heap.Pop(&o.pools[0].jobs)
+	o.fixPool()
+}
+
+func (o *fairOrder) fixPool() {
+	if len(o.pools[0].jobs) == 0 {
+		heap.Pop(&o.pools)
+	} else {
+		heap.Fix(&o.pools, 0)
+	}
+}
+
+// poolTurn es un pool dentro de fairOrder, con sus tareas en curso.
+type poolTurn struct {
+	name    string
+	cfg     Pool
+	running int
+	jobs    jobHeap
+}
+
+// needy indica si el pool corre menos que su MinShare; share es lo que
+// ordena a los pools de una misma clase.
+func (p *poolTurn) needy() bool {
+	return p.cfg.MinShare > 0 && p.running < p.cfg.MinShare
+}
+
+func (p *poolTurn) share() float64 {
+	if p.needy() {
+		return float64(p.running) / float64(p.cfg.MinShare)
+	}
+	return float64(p.running) / float64(max(p.cfg.Weight, 1))
+}
+
+type poolHeap []*poolTurn
+
+func (h poolHeap) Len() int      { return len(h) }
+func (h poolHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h poolHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.needy() != b.needy() {
+		return a.needy()
+	}
+	if sa, sb := a.share(), b.share(); sa != sb {
+		return sa < sb
+	}
+	return a.name < b.name
+}
+func (h *poolHeap) Push(x any) { *h = append(*h, x.(*poolTurn)) }
+func (h *poolHeap) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return p
+}
+
+// jobTurn es un job dentro de su pool en fairOrder, con sus tareas en curso.
+type jobTurn struct {
+	q       *jobQueue
+	running int
+}
+
+type jobHeap []*jobTurn
+
+func (h jobHeap) Len() int      { return len(h) }
+func (h jobHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h jobHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	sa := float64(a.running) / float64(a.q.priority)
+	sb := float64(b.running) / float64(b.q.priority)
+	if sa != sb {
+		return sa < sb
+	}
+	return a.q.seq < b.q.seq
+}
+func (h *jobHeap) Push(x any) { *h = append(*h, x.(*jobTurn)) }
+func (h *jobHeap) Pop() any {
+	old := *h
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return j
+}
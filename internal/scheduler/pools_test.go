@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pushJob encola n tareas del job en el pool, con esa prioridad.
+func pushJob(q *TaskQueue, jobID, pool string, priority, n int) {
+	for i := 0; i < n; i++ {
+		q.Push(&TaskSpec{JobID: jobID, TaskID: fmt.Sprintf("%s-%d", jobID, i), Pool: pool, Priority: priority})
+	}
+}
+
+// takeOrder saca n tareas de a una y devuelve de qué job es cada una.
+func takeOrder(q *TaskQueue, n int) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out, _ := q.takeLocked(n, nil)
+	jobs := make([]string, len(out))
+	for i, t := range out {
+		jobs[i] = t.JobID
+	}
+	return strings.Join(jobs, " ")
+}
+
+func TestFairShareOrder(t *testing.T) {
+	cases := []struct {
+		name  string
+		pools map[string]Pool
+		setup func(q *TaskQueue)
+		usage Usage
+		n     int
+		want  string
+	}{
+		{
+			name: "round robin between equal jobs, older first",
+			setup: func(q *TaskQueue) {
+				pushJob(q, "a", "", 1, 3)
+				pushJob(q, "b", "", 1, 3)
+			},
+			n:    4,
+			want: "a b a b",
+		},
+		{
+			name: "priority is the job's weight",
+			setup: func(q *TaskQueue) {
+				pushJob(q, "a", "", 1, 4)
+				pushJob(q, "b", "", 2, 4)
+			},
+			n:    6,
+			want: "a b b a b b",
+		},
+		{
+			name: "running tasks count against the job",
+			setup: func(q *TaskQueue) {
+				pushJob(q, "a", "", 1, 3)
+				pushJob(q, "b", "", 1, 3)
+			},
+			usage: Usage{Jobs: map[string]int{"a": 2}, Pools: map[string]int{DefaultPool: 2}},
+			n:     4,
+			want:  "b b a b",
+		},
+		{
+			name:  "pools split by weight",
+			pools: map[string]Pool{"big": {Weight: 3}},
+			setup: func(q *TaskQueue) {
+				pushJob(q, "a", "big", 1, 6)
+				pushJob(q, "b", "", 1, 6)
+			},
+			n:    8,
+			want: "a b a a a b a a",
+		},
+		{
+			name:  "a pool below its min share goes first",
+			pools: map[string]Pool{"small": {Weight: 1, MinShare: 2}},
+			setup: func(q *TaskQueue) {
+				pushJob(q, "a", "", 1, 4)
+				pushJob(q, "b", "small", 1, 4)
+			},
+			usage: Usage{Jobs: map[string]int{"a": 5}, Pools: map[string]int{DefaultPool: 5}},
+			n:     4,
+			want:  "b b b b",
+		},
+	}
+	for _, c := range cases {
+		q := NewTaskQueue()
+		q.SetPools(c.pools)
+		if c.usage.Jobs != nil {
+			q.usageFn = func() Usage {
+				u := Usage{Jobs: map[string]int{}, Pools: map[string]int{}}
+				for k, v := range c.usage.Jobs {
+					u.Jobs[k] = v
+				}
+				for k, v := range c.usage.Pools {
+					u.Pools[k] = v
+				}
+				return u
+			}
+		}
+		c.setup(q)
+		if got := takeOrder(q, c.n); got != c.want {
+			t.Errorf("%s: order = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFairShareSkipsJobsThatDoNotFit(t *testing.T) {
+	q := NewTaskQueue()
+	pushJob(q, "a", "", 1, 2)
+	pushJob(q, "b", "", 1, 2)
+
+	q.mu.Lock()
+	out, _ := q.takeLocked(3, func(t *TaskSpec) bool { return t.JobID == "b" })
+	q.mu.Unlock()
+	if len(out) != 2 || out[0].JobID != "b" || out[1].JobID != "b" {
+		t.Fatalf("took %v, want both tasks of b", out)
+	}
+	if q.Len() != 2 {
+		t.Errorf("queue has %d tasks, want the 2 of a", q.Len())
+	}
+}
@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"batchdag/internal/core"
+)
+
+// Reconcile pone al día al scheduler con lo que cuenta un worker al volver
+// a registrarse, típicamente después de un reinicio del master: los
+// intentos que sigue corriendo se adoptan como en curso en vez de correrlos
+// de nuevo, y las salidas de shuffle que el master creía en él y ya no
+// tiene se recalculan (ver JobManager.OutputsMissing). Si el que reinició
+// fue el worker (antes de que el master lo diera por DOWN), los intentos
+// que el master tenía en curso en él y no aparecen en running se perdieron:
+// se liberan y se reencolan como si el worker hubiera caído.
+func (s *Scheduler) Reconcile(workerID string, running []core.RunningAttempt, outputs []string) {
+	worker, ok := s.registry.Get(workerID)
+	if !ok {
+		return
+	}
+	registered := time.Now()
+
+	adopted := 0
+	reported := map[string]bool{}
+	for _, ra := range running {
+		reported[ra.AttemptID] = true
+		if s.adopt(worker, ra) {
+			adopted++
+			continue
+		}
+		log.Printf("Worker %s: dropping attempt %d of task %s, the master no longer needs it\n", worker.ID, ra.Attempt, ra.TaskID)
+		s.cancelOnWorker(worker, ra.JobID, ra.TaskID)
+	}
+
+	// los despachados durante el registro todavía no podían estar en running
+	lost := s.releaseWhere(func(rt *runningTask) bool {
+		return rt.worker.ID == workerID && !reported[rt.spec.AttemptID] && rt.started.Before(registered)
+	})
+	if len(lost) > 0 {
+		log.Printf("Worker %s lost %d running tasks while restarting, rescheduling them\n", workerID, len(lost))
+		s.requeueLost(workerID, lost, "worker restarted")
+	}
+
+	next, voided := s.jm.OutputsMissing(workerID, outputs)
+	s.abandon(voided)
+	for _, a := range next {
+		s.EnqueueAssignment(a)
+	}
+	if len(running) > 0 || len(next) > 0 {
+		log.Printf("Worker %s reconciled: %d running tasks adopted, %d dropped, %d lost shuffle outputs to recompute\n",
+			worker.ID, adopted, len(running)-adopted, len(next))
+	}
+}
+
+// adopt toma como intento en curso en worker el intento ra que el worker
+// dice estar corriendo, si es el que la tarea espera: la tarea está
+// encolada (el master la reencoló al recuperarse) con ese mismo número de
+// intento. Devuelve false si el intento sobra.
+func (s *Scheduler) adopt(worker *core.WorkerInfo, ra core.RunningAttempt) bool {
+	s.mu.Lock()
+	for _, rt := range s.attemptsLocked(ra.TaskID) {
+		if rt.jobID == ra.JobID && rt.worker.ID == worker.ID && rt.spec.AttemptID == ra.AttemptID {
+			// el master ya lo tenía en curso: sólo se registró de nuevo
+			rt.worker = worker
+			s.mu.Unlock()
+			return true
+		}
+	}
+	s.mu.Unlock()
+
+	if !s.jm.TaskRunnable(ra.JobID, ra.TaskID) {
+		return false
+	}
+	t := s.queue.TakeTask(ra.TaskID)
+	if t == nil {
+		// ya corre en otro worker
+		return false
+	}
+	if t.JobID != ra.JobID || t.Attempts != ra.Attempt {
+		s.queue.Push(t)
+		return false
+	}
+	// el intento sigue con el ID con que lo despachó el master anterior
+	t.AttemptID = ra.AttemptID
+	if !s.jm.StartAttempt(t.JobID, t.TaskID, t.AttemptID) {
+		return false
+	}
+
+	level, _ := s.locality(t, worker, s.registry.List())
+	rt := &runningTask{jobID: t.JobID, worker: worker, spec: t, locality: level, started: time.Now()}
+	s.mu.Lock()
+	s.reserveLocked(worker, t)
+	s.running[t.AttemptID] = rt
+	if worker.Mode == core.WorkerPull {
+		s.armLeaseLocked(rt)
+	}
+	s.armTimeoutLocked(rt)
+	s.mu.Unlock()
+	return true
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	"batchdag/internal/core"
+)
+
+func TestReconcileRequeuesAttemptsLostInRestart(t *testing.T) {
+	s, jm, first := runningJob(t, 2)
+	second := strings.TrimSuffix(first, "0") + "1"
+	s.registry.Register("w1", "http://w1", core.WorkerPush, core.WorkerResources{Slots: 2})
+	w, _ := s.registry.Get("w1")
+	kept := dispatch(t, s, first, w)
+	lost := dispatch(t, s, second, w)
+
+	// el worker reinició antes de que el master lo diera por DOWN: sólo
+	// sigue corriendo (o por reportar) el primer intento
+	s.Reconcile("w1", []core.RunningAttempt{{JobID: "job-1", TaskID: first, AttemptID: kept.AttemptID}}, nil)
+
+	if !s.stillRunning(first) {
+		t.Errorf("the attempt the worker still runs was released")
+	}
+	if s.stillRunning(second) {
+		t.Errorf("the attempt the worker lost is still running on the master")
+	}
+	if q := s.queue.Len(); q != 1 {
+		t.Errorf("queue has %d tasks, want the lost one requeued", q)
+	}
+	j, _ := jm.Get("job-1")
+	h := j.Tasks[second].History
+	if len(h) != 1 || h[0].Status != "LOST" || h[0].AttemptID != lost.AttemptID {
+		t.Errorf("history of the lost task = %+v, want its attempt LOST", h)
+	}
+}
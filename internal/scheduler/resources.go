@@ -0,0 +1,166 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"batchdag/internal/core"
+)
+
+// Una tarea que pide más memoria o más CPUs de las que declara en total
+// cada uno de los workers registrados (en cualquier estado: uno caído puede
+// volver) no va a correr nunca. En vez de dejarla en la cola para siempre se
+// la da por fallida, con un error que dice por qué; el JobManager aplica la
+// política del job. Se revisa al encolarla y cada vez que un worker se
+// registra o vuelve. Mientras no haya workers registrados se espera.
+
+// unschedulable indica si ningún worker de workers podría correr t aunque
+// estuviera libre, y por qué.
+func unschedulable(t *TaskSpec, workers []*core.WorkerInfo) (string, bool) {
+	if (t.MemoryMB <= 0 && t.CPUs <= 0) || len(workers) == 0 {
+		return "", false
+	}
+	var maxMem int64
+	maxCPUs := 0
+	for _, w := range workers {
+		r := w.Resources
+		memOK := r.MemoryMB <= 0 || r.MemoryMB >= t.MemoryMB
+		cpusOK := r.CPUs <= 0 || r.CPUs >= t.CPUs
+		if memOK && cpusOK {
+			return "", false
+		}
+		maxMem, maxCPUs = max(maxMem, r.MemoryMB), max(maxCPUs, r.CPUs)
+	}
+	return fmt.Sprintf("needs %d MB of memory and %d CPUs, but no worker has that much (largest: %d MB, %d CPUs)",
+		t.MemoryMB, t.CPUs, maxMem, maxCPUs), true
+}
+
+// failUnschedulable saca de la cola las tareas que ya no puede correr
+// ningún worker y las da por fallidas.
+func (s *Scheduler) failUnschedulable() {
+	workers := s.registry.List()
+	reasons := map[*TaskSpec]string{}
+	s.queue.removeIf(func(t *TaskSpec) bool {
+		msg, ok := unschedulable(t, workers)
+		if ok {
+			reasons[t] = msg
+		}
+		return ok
+	})
+	for t, msg := range reasons {
+		s.failUnscheduled(t, msg)
+	}
+}
+
+// failUnscheduled da por fallida, sin intentarla, una tarea que ningún
+// worker puede correr.
+func (s *Scheduler) failUnscheduled(t *TaskSpec, msg string) {
+	log.Printf("Task %s cannot be scheduled: %s\n", t.TaskID, msg)
+	s.jm.FailTask(t.JobID, t.TaskID, "", "cannot be scheduled: "+msg)
+}
+
+// capacity es una foto de los workers y de lo que tienen libre, que se toma
+// una vez por pasada por la cola (ver TaskQueue.PopWait) en vez de una vez
+// por tarea: candidates son los workers UP que pueden recibir tareas en esa
+// pasada, de menos a más tareas en curso.
+type capacity struct {
+	workers    []*core.WorkerInfo // la lista del registry
+	candidates []*core.WorkerInfo
+	free       map[string]*freeResources
+	banned     map[string]bool            // workerID excluido para todos los jobs
+	excluded   map[string]map[string]bool // jobID -> workerID excluido
+}
+
+type freeResources struct {
+	slots int
+	memMB int64
+	cpus  int
+}
+
+// snapshot toma la foto con los workers UP (y ya reconciliados) que cumplen
+// eligible como candidatos.
+func (s *Scheduler) snapshot(eligible func(w *core.WorkerInfo) bool) *capacity {
+	workers := s.registry.List()
+	c := &capacity{
+		workers:  workers,
+		free:     make(map[string]*freeResources),
+		banned:   make(map[string]bool),
+		excluded: make(map[string]map[string]bool),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range workers {
+		if w.State != core.WorkerUp || w.Restored() || !eligible(w) {
+			continue
+		}
+		slots, mem, cpus := s.freeLocked(w)
+		if slots <= 0 {
+			continue
+		}
+		c.candidates = append(c.candidates, w)
+		c.free[w.ID] = &freeResources{slots: slots, memMB: mem, cpus: cpus}
+	}
+	sort.SliceStable(c.candidates, func(i, j int) bool {
+		return s.activeTasks[c.candidates[i].ID] < s.activeTasks[c.candidates[j].ID]
+	})
+
+	now := time.Now()
+	for id := range s.blacklist.cluster {
+		if !s.usableLocked("", id, now) {
+			c.banned[id] = true
+		}
+	}
+	for jobID, ids := range s.blacklist.jobs {
+		for id := range ids {
+			if !s.usableLocked(jobID, id, now) {
+				if c.excluded[jobID] == nil {
+					c.excluded[jobID] = make(map[string]bool)
+				}
+				c.excluded[jobID][id] = true
+			}
+		}
+	}
+	return c
+}
+
+// fits indica si a w le queda lugar para t en la foto.
+func (c *capacity) fits(w *core.WorkerInfo, t *TaskSpec) bool {
+	f := c.free[w.ID]
+	return f != nil && f.slots > 0 && t.MemoryMB <= f.memMB && t.CPUs <= f.cpus
+}
+
+// take descuenta t de lo libre de w para el resto de la pasada.
+func (c *capacity) take(w *core.WorkerInfo, t *TaskSpec) {
+	if f := c.free[w.ID]; f != nil {
+		f.slots--
+		f.memMB -= t.MemoryMB
+		f.cpus -= t.CPUs
+	}
+}
+
+// usable indica si w puede recibir tareas del job (no está excluido).
+func (c *capacity) usable(jobID, workerID string) bool {
+	return !c.banned[workerID] && !c.excluded[jobID][workerID]
+}
+
+// allowed indica si t puede ir a w, aparte del lugar libre y la localidad:
+// no es el worker del intento original si t es una copia especulativa y,
+// mientras haya otro worker UP que pueda tomar t, w no está excluido ni es
+// el worker donde falló el intento anterior.
+func (c *capacity) allowed(t *TaskSpec, w *core.WorkerInfo) bool {
+	if t.avoid == w.ID {
+		return false
+	}
+	if c.usable(t.JobID, w.ID) && t.lastFailed != w.ID {
+		return true
+	}
+	for _, o := range c.workers {
+		if o.ID != w.ID && o.State == core.WorkerUp && c.usable(t.JobID, o.ID) {
+			return false
+		}
+	}
+	return true
+}
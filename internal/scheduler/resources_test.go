@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"testing"
+
+	"batchdag/internal/core"
+)
+
+func TestUnschedulable(t *testing.T) {
+	worker := func(mem int64, cpus int, state core.WorkerState) *core.WorkerInfo {
+		return &core.WorkerInfo{State: state, Resources: core.WorkerResources{Slots: 1, MemoryMB: mem, CPUs: cpus}}
+	}
+	cases := []struct {
+		name    string
+		task    TaskSpec
+		workers []*core.WorkerInfo
+		want    bool
+	}{
+		{"no request", TaskSpec{}, []*core.WorkerInfo{worker(512, 1, core.WorkerUp)}, false},
+		{"fits", TaskSpec{MemoryMB: 512, CPUs: 1}, []*core.WorkerInfo{worker(1024, 2, core.WorkerUp)}, false},
+		{"too much memory", TaskSpec{MemoryMB: 2048}, []*core.WorkerInfo{worker(1024, 2, core.WorkerUp)}, true},
+		{"too many cpus", TaskSpec{CPUs: 4}, []*core.WorkerInfo{worker(1024, 2, core.WorkerUp)}, true},
+		{"unknown memory is unlimited", TaskSpec{MemoryMB: 1 << 20}, []*core.WorkerInfo{worker(0, 2, core.WorkerUp)}, false},
+		{"down worker may come back", TaskSpec{MemoryMB: 2048},
+			[]*core.WorkerInfo{worker(1024, 2, core.WorkerUp), worker(4096, 2, core.WorkerDown)}, false},
+		{"memory and cpus on different workers", TaskSpec{MemoryMB: 2048, CPUs: 4},
+			[]*core.WorkerInfo{worker(4096, 2, core.WorkerUp), worker(1024, 8, core.WorkerUp)}, true},
+		{"no workers yet", TaskSpec{MemoryMB: 2048}, nil, false},
+	}
+	for _, c := range cases {
+		if _, got := unschedulable(&c.task, c.workers); got != c.want {
+			t.Errorf("%s: unschedulable = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFreeTreatsUnknownResourcesAsUnlimited(t *testing.T) {
+	s := NewScheduler(core.NewWorkerRegistry(), core.NewJobManager(), NewTaskQueue())
+	w := &core.WorkerInfo{ID: "w1", Resources: core.WorkerResources{Slots: 2}}
+	s.reserveLocked(w, &TaskSpec{MemoryMB: 4096, CPUs: 2})
+
+	slots, mem, cpus := s.freeLocked(w)
+	if slots != 1 || mem < 1<<40 || cpus < 1<<20 {
+		t.Errorf("free = %d slots, %d MB, %d CPUs; want 1 slot and no memory or CPU limit", slots, mem, cpus)
+	}
+}
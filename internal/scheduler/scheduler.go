@@ -2,57 +2,122 @@ package scheduler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
-	"sort"
 	"sync"
 	"time"
 
 	"batchdag/internal/core"
+	"batchdag/internal/dag"
 )
 
+// DefaultTaskTimeout es el TaskTimeout de un Scheduler nuevo.
+const DefaultTaskTimeout = 30 * time.Minute
+
+// dispatchWait es lo más que espera el loop de Start sin volver a mirar la
+// cola; normalmente lo despierta antes algún cambio (ver TaskQueue.PopWait).
+// Cubre lo que no avisa, como el fin de una exclusión (ver failures.go).
+const dispatchWait = 30 * time.Second
+
 type Scheduler struct {
 	registry    *core.WorkerRegistry
 	jm          *core.JobManager
 	queue       *TaskQueue
 	client      *http.Client
 	activeTasks map[string]int
+	reservedMem map[string]int64        // workerID -> MB pedidos por sus tareas en curso
+	reservedCPU map[string]int          // workerID -> CPUs pedidas por sus tareas en curso
+	running     map[string]*runningTask // intentos en curso, por AttemptID
 	mu          sync.Mutex
 	maxAttempts int
+	// localityWait es cuánto espera una tarea a que se libere un worker
+	// preferido antes de aceptar cualquiera (delay scheduling)
+	localityWait time.Duration
+
+	// Speculation, Blacklist y TaskTimeout se configuran (desde main) antes
+	// de Start. TaskTimeout es el timeout de los intentos de los stages que
+	// no declaran uno: sin él, un intento que el worker nunca informa (se
+	// colgó, o se perdió el reporte) ocuparía su lugar para siempre.
+	Speculation SpeculationConfig
+	Blacklist   BlacklistConfig
+	TaskTimeout time.Duration
+	blacklist   *blacklist
+	durations   map[string][]time.Duration // stage -> duración de sus tareas exitosas
+	speculated  map[string]bool            // taskID -> ya tiene copia especulativa
 }
 
 func NewScheduler(reg *core.WorkerRegistry, jm *core.JobManager, q *TaskQueue) *Scheduler {
-	return &Scheduler{
+	s := &Scheduler{
 		registry:    reg,
 		jm:          jm,
 		queue:       q,
 		client:      &http.Client{Timeout: 10 * time.Second},
 		activeTasks: make(map[string]int),
+		reservedMem: make(map[string]int64),
+		reservedCPU: make(map[string]int),
+		running:     make(map[string]*runningTask),
 		maxAttempts: 3,
+
+		localityWait: 3 * time.Second,
+
+		Speculation: DefaultSpeculation,
+		Blacklist:   DefaultBlacklist,
+		TaskTimeout: DefaultTaskTimeout,
+		blacklist:   newBlacklist(),
+		durations:   make(map[string][]time.Duration),
+		speculated:  make(map[string]bool),
 	}
+	q.usageFn = s.usage
+	q.localityWait = s.localityWait
+	return s
 }
 
 func (s *Scheduler) Start() {
+	s.registry.Subscribe(s.onWorkerStateChange)
+
+	if s.Speculation.Enabled {
+		go func() {
+			for {
+				time.Sleep(time.Second)
+				s.speculate()
+			}
+		}()
+	}
+
 	go func() {
 		for {
-			task := s.queue.Pop()
-			if task == nil {
+			// saca la primera tarea que tenga un worker en push donde
+			// correr (las de los workers en pull las reparte Lease); si no
+			// hay ninguna, PopWait espera a que llegue una tarea, se libere
+			// lugar, cambie un worker o venza alguna espera (ver TaskQueue.
+			// PopWait) sin volver a recorrer la cola. Cada pasada mira una
+			// sola foto de los workers (ver capacity).
+			var worker *core.WorkerInfo
+			var level string
+			pass := func() func(t *TaskSpec) bool {
+				c := s.snapshot(func(w *core.WorkerInfo) bool { return w.Mode != core.WorkerPull })
+				return func(t *TaskSpec) bool {
+					worker, level = s.pickWorker(c, t)
+					return worker != nil
+				}
+			}
+			tasks := s.queue.PopWait(context.Background(), 1, dispatchWait, pass)
+			if len(tasks) == 0 {
 				continue
 			}
-
-			worker := s.pickWorker()
-			if worker == nil {
-				log.Println("No worker available. Requeueing", task.TaskID)
-				time.Sleep(1 * time.Second)
-				s.queue.Push(task)
+			task := tasks[0]
+			if !s.startAttempt(task) {
 				continue
 			}
 
 			s.mu.Lock()
-			s.activeTasks[worker.ID]++
+			s.reserveLocked(worker, task)
+			s.running[task.AttemptID] = &runningTask{jobID: task.JobID, worker: worker, spec: task, locality: level, started: time.Now()}
 			s.mu.Unlock()
 
 			go s.dispatchTask(worker, task)
@@ -60,26 +125,60 @@ func (s *Scheduler) Start() {
 	}()
 }
 
-func (s *Scheduler) pickWorker() *core.WorkerInfo {
-	workers := s.registry.List()
-
-	var up []*core.WorkerInfo
-	for _, w := range workers {
-		if w.State == core.WorkerUp {
-			up = append(up, w)
+// pickWorker elige, entre los workers de c que tienen un slot libre y la
+// memoria y las CPUs que pide t, el que menos tareas está corriendo,
+// prefiriendo los que ya tienen el input de t (ver locality). Devuelve
+// también el nivel de localidad, o nil si t tiene que seguir esperando.
+func (s *Scheduler) pickWorker(c *capacity, t *TaskSpec) (*core.WorkerInfo, string) {
+	var fallback *core.WorkerInfo
+	var fallbackLevel string
+	for _, w := range c.candidates {
+		if !c.fits(w, t) || !c.allowed(t, w) {
+			continue
 		}
+		level, ok := s.locality(t, w, c.workers)
+		if level == core.LocalityNode {
+			return w, level
+		}
+		if ok && fallback == nil {
+			fallback, fallbackLevel = w, level
+		}
+	}
+	return fallback, fallbackLevel
+}
+
+// freeLocked devuelve los slots, la memoria y las CPUs que le quedan libres
+// a w según lo que declaró y las tareas que tiene en curso. La memoria o las
+// CPUs que el worker no sabe (declaró 0) no tienen límite.
+func (s *Scheduler) freeLocked(w *core.WorkerInfo) (slots int, memMB int64, cpus int) {
+	memMB, cpus = math.MaxInt64, math.MaxInt
+	if w.Resources.MemoryMB > 0 {
+		memMB = w.Resources.MemoryMB - s.reservedMem[w.ID]
 	}
-	if len(up) == 0 {
-		return nil
+	if w.Resources.CPUs > 0 {
+		cpus = w.Resources.CPUs - s.reservedCPU[w.ID]
 	}
+	return w.Resources.Slots - s.activeTasks[w.ID], memMB, cpus
+}
 
+// usage cuenta las tareas en curso por job y por pool, para el fair share
+// de la cola.
+func (s *Scheduler) usage() Usage {
 	s.mu.Lock()
-	sort.SliceStable(up, func(i, j int) bool {
-		return s.activeTasks[up[i].ID] < s.activeTasks[up[j].ID]
-	})
-	s.mu.Unlock()
+	defer s.mu.Unlock()
+	u := Usage{Jobs: map[string]int{}, Pools: map[string]int{}}
+	for _, rt := range s.running {
+		u.Jobs[rt.jobID]++
+		u.Pools[poolName(rt.spec.Pool)]++
+	}
+	return u
+}
 
-	return up[0]
+// reserveLocked cuenta a t entre las tareas en curso de w.
+func (s *Scheduler) reserveLocked(w *core.WorkerInfo, t *TaskSpec) {
+	s.activeTasks[w.ID]++
+	s.reservedMem[w.ID] += t.MemoryMB
+	s.reservedCPU[w.ID] += t.CPUs
 }
 
 type workerTaskPayload struct {
@@ -87,28 +186,46 @@ type workerTaskPayload struct {
 	TaskID    string                 `json:"task_id"`
 	StageID   string                 `json:"stage_id"`
 	Partition int                    `json:"partition"`
+	Attempt   int                    `json:"attempt"`
+	AttemptID string                 `json:"attempt_id"`
 	Op        string                 `json:"op,omitempty"`
 	Params    map[string]interface{} `json:"params,omitempty"`
-}
+	Input     []interface{}          `json:"input,omitempty"`
+	Splits    []dag.InputSplit       `json:"splits,omitempty"`
 
-func (s *Scheduler) dispatchTask(worker *core.WorkerInfo, t *TaskSpec) {
-	defer func() {
-		s.mu.Lock()
-		if s.activeTasks[worker.ID] > 0 {
-			s.activeTasks[worker.ID]--
-		}
-		s.mu.Unlock()
-	}()
+	ShuffleWrites []dag.ShuffleWrite `json:"shuffle_writes,omitempty"`
+	ShuffleRead   *dag.ShuffleRead   `json:"shuffle_read,omitempty"`
+	DiscardOutput bool               `json:"discard_output,omitempty"`
+	CacheWrite    *dag.CacheWrite    `json:"cache_write,omitempty"`
+	CacheRead     *dag.CacheRead     `json:"cache_read,omitempty"`
+}
 
-	payload := workerTaskPayload{
+func payloadFor(t *TaskSpec) workerTaskPayload {
+	return workerTaskPayload{
 		JobID:     t.JobID,
 		TaskID:    t.TaskID,
 		StageID:   t.StageID,
 		Partition: t.Partition,
+		Attempt:   t.Attempts,
+		AttemptID: t.AttemptID,
 		Op:        t.Op,
 		Params:    t.Params,
+		Input:     t.Input,
+		Splits:    t.Splits,
+
+		ShuffleWrites: t.ShuffleWrites,
+		ShuffleRead:   t.ShuffleRead,
+		DiscardOutput: t.DiscardOutput,
+		CacheWrite:    t.CacheWrite,
+		CacheRead:     t.CacheRead,
 	}
-	b, _ := json.Marshal(payload)
+}
+
+// dispatchTask entrega la tarea al worker, que la acepta (202) y la corre
+// en segundo plano; el resultado llega después por HandleReport. Si la tarea
+// tiene timeout, desde que se acepta corre el reloj.
+func (s *Scheduler) dispatchTask(worker *core.WorkerInfo, t *TaskSpec) {
+	b, _ := json.Marshal(payloadFor(t))
 
 	url := fmt.Sprintf("%s/task", worker.Host)
 	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(b))
@@ -116,8 +233,10 @@ func (s *Scheduler) dispatchTask(worker *core.WorkerInfo, t *TaskSpec) {
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		log.Printf("Task %s failed on %s: err=%v\n", t.TaskID, worker.ID, err)
-		s.handleFailure(worker, t)
+		if rt, ok := s.release(t.AttemptID); ok {
+			log.Printf("Task %s failed on %s: err=%v\n", t.TaskID, worker.ID, err)
+			s.handleFailure(rt, err.Error(), true)
+		}
 		return
 	}
 	defer resp.Body.Close()
@@ -125,48 +244,200 @@ func (s *Scheduler) dispatchTask(worker *core.WorkerInfo, t *TaskSpec) {
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode >= 400 {
-		log.Printf("Task %s failed on %s: status=%d body=%s\n", t.TaskID, worker.ID, resp.StatusCode, string(body))
-		s.handleFailure(worker, t)
+		if rt, ok := s.release(t.AttemptID); ok {
+			log.Printf("Task %s failed on %s: status=%d body=%s\n", t.TaskID, worker.ID, resp.StatusCode, string(body))
+			s.handleFailure(rt, fmt.Sprintf("status %d: %s", resp.StatusCode, bytes.TrimSpace(body)), resp.StatusCode >= 500)
+		}
 		return
 	}
 
-	// parse possible output: {"status":"ok","output":[...]}
-	var parsed struct {
-		Status string        `json:"status"`
-		Output []interface{} `json:"output,omitempty"`
-	}
-	_ = json.Unmarshal(body, &parsed)
-
-	// save results into job manager (store raw JSON-serializable output)
-	if len(parsed.Output) > 0 {
-		s.jm.UpdateTask(t.JobID, t.TaskID, func(jt *core.JobTask) {
-			// store outputs as a slice of interface{} (marshal later if needed)
-			jt.Result = parsed.Output
-			jt.Status = "DONE"
-			jt.AssignedTo = worker.ID
-		})
-	} else {
-		s.jm.UpdateTask(t.JobID, t.TaskID, func(jt *core.JobTask) {
-			jt.Status = "DONE"
-			jt.AssignedTo = worker.ID
-		})
+	s.mu.Lock()
+	if rt, ok := s.running[t.AttemptID]; ok {
+		s.armTimeoutLocked(rt)
 	}
+	s.mu.Unlock()
+}
 
-	log.Printf("Task %s completed on worker %s\n", t.TaskID, worker.ID)
+// handleFailure reintenta la tarea hasta maxAttempts; agotados los
+// reintentos la da por fallida y el JobManager aplica la política del job.
+// El reintento vuelve a la cola en seguida pero no sale hasta que pasa su
+// backoff, y evita el worker donde falló (ver allowed). Si a la tarea le
+// queda otro intento en curso o en cola (especulación), el fallo no cuenta
+// como intento: la tarea sigue con ese. infra indica que falló el worker o la
+// red y no el código de la tarea (ver noteFailure).
+func (s *Scheduler) handleFailure(rt *runningTask, errMsg string, infra bool) {
+	s.retryAttempt(rt, "FAILED", errMsg, infra)
 }
 
-func (s *Scheduler) handleFailure(worker *core.WorkerInfo, t *TaskSpec) {
-	t.Attempts++
+// retryAttempt es handleFailure con el estado con que el intento queda en
+// el historial (FAILED, o LOST si se perdió su lease). El reintento se
+// encola como un TaskSpec nuevo: el del intento fallido lo pueden tener
+// todavía sus relojes de timeout y de lease.
+func (s *Scheduler) retryAttempt(rt *runningTask, status, errMsg string, infra bool) {
+	t, worker := rt.spec, rt.worker
+	if !s.jm.TaskRunnable(t.JobID, t.TaskID) {
+		return
+	}
+	if infra {
+		s.noteFailure(t.JobID, worker.ID)
+	}
+	if s.stillRunning(t.TaskID) || s.queue.Queued(t.JobID, t.TaskID) {
+		log.Printf("Task %s: attempt on %s failed, another attempt is still running or queued\n", t.TaskID, worker.ID)
+		s.recordAttempt(rt, status, errMsg)
+		return
+	}
+	s.recordAttempt(rt, status, errMsg)
+	next := t.retry()
+	next.Attempts++
+	retry := next.Attempts < s.maxAttempts
 	s.jm.UpdateTask(t.JobID, t.TaskID, func(jt *core.JobTask) {
-		jt.Attempts = t.Attempts
+		jt.Attempts = next.Attempts
 		jt.AssignedTo = worker.ID
-		jt.Status = "FAILED"
+		jt.Error = errMsg
+		if retry {
+			// espera su reintento: si el job se detiene antes queda
+			// CANCELLED; FAILED lo pone FailTask al agotar los intentos
+			jt.Status = "PENDING"
+		}
 	})
-	if t.Attempts < s.maxAttempts {
-		time.Sleep(300 * time.Millisecond)
-		s.queue.Push(t)
+	if retry {
+		next.notBefore = time.Now().Add(retryBackoff(next.Attempts))
+		next.lastFailed = worker.ID
+		s.queue.Push(next)
 	} else {
-		// permanent fail - keep status FAILED
+		log.Printf("Task %s failed permanently after %d attempts\n", t.TaskID, next.Attempts)
+		s.jm.FailTask(t.JobID, t.TaskID, worker.ID, errMsg)
+	}
+}
+
+// onWorkerStateChange recibe los cambios de estado del registry. Cuando un
+// worker pasa a DOWN, sus intentos en curso se abandonan y se reencolan, y
+// el JobManager recalcula las salidas de shuffle que vivían en él.
+func (s *Scheduler) onWorkerStateChange(w core.WorkerInfo, prev core.WorkerState) {
+	if w.State == core.WorkerUp {
+		// un worker que vuelve (o que se reconcilió) puede tomar tareas, y
+		// cambia lo que los workers pueden correr
+		s.failUnschedulable()
+		s.queue.Wake()
+		return
+	}
+	if w.State != core.WorkerDown || prev == core.WorkerDown {
+		return
+	}
+
+	lost := s.releaseWhere(func(rt *runningTask) bool { return rt.worker.ID == w.ID })
+	log.Printf("Worker %s is DOWN: rescheduling %d running tasks\n", w.ID, len(lost))
+	s.requeueLost(w.ID, lost, "worker down")
+
+	next, voided := s.jm.WorkerLost(w.ID)
+	s.abandon(voided)
+	for _, a := range next {
+		s.EnqueueAssignment(a)
+	}
+}
+
+// requeueLost anota como LOST los intentos ya liberados que se perdieron
+// con el worker workerID y vuelve a encolar sus tareas, salvo las que
+// tienen otro intento en curso o en cola. Perder el worker cuenta como un
+// fallo suyo, una vez por job.
+func (s *Scheduler) requeueLost(workerID string, lost []*runningTask, why string) {
+	counted := map[string]bool{}
+	for _, rt := range lost {
+		if !counted[rt.jobID] {
+			counted[rt.jobID] = true
+			s.noteFailure(rt.jobID, workerID)
+		}
+	}
+	for _, rt := range lost {
+		s.recordAttempt(rt, "LOST", why)
+		if !s.stillRunning(rt.spec.TaskID) && !s.queue.Queued(rt.jobID, rt.spec.TaskID) {
+			s.queue.Push(rt.spec.retry())
+		}
+	}
+}
+
+// cacheMiss atiende un intento que no encontró su partición en la caché:
+// en vez de reintentar la lectura, el JobManager recalcula el stage por
+// linaje. Devuelve false si el stage ya no se lee de la caché; entonces el
+// fallo sigue el camino de siempre.
+func (s *Scheduler) cacheMiss(rt *runningTask, errMsg string) bool {
+	next, voided, ok := s.jm.CacheMissed(rt.jobID, rt.spec.TaskID)
+	if !ok {
+		return false
+	}
+	s.recordAttempt(rt, "FAILED", errMsg)
+	s.abandon(voided)
+	for _, a := range next {
+		s.EnqueueAssignment(a)
+	}
+	return true
+}
+
+// abandon descarta los intentos de estas tareas, en cola o en curso.
+func (s *Scheduler) abandon(taskIDs []string) {
+	if len(taskIDs) == 0 {
+		return
+	}
+	ids := make(map[string]bool, len(taskIDs))
+	for _, id := range taskIDs {
+		ids[id] = true
+	}
+	s.queue.RemoveTasks(ids)
+
+	s.mu.Lock()
+	for id := range ids {
+		delete(s.speculated, id)
+	}
+	s.mu.Unlock()
+
+	for _, rt := range s.releaseWhere(func(rt *runningTask) bool { return ids[rt.spec.TaskID] }) {
+		s.cancelOnWorker(rt.worker, rt.jobID, rt.spec.TaskID)
+	}
+}
+
+// CancelJob saca de la cola las tareas del job y pide a cada worker que
+// esté corriendo alguna que la cancele (POST /task/cancel).
+func (s *Scheduler) CancelJob(jobID string) {
+	removed := s.queue.RemoveJob(jobID)
+
+	s.mu.Lock()
+	s.forgetJobLocked(jobID)
+	s.forgetJobBlacklistLocked(jobID)
+	s.mu.Unlock()
+
+	hosts := map[string]*core.WorkerInfo{}
+	for _, rt := range s.releaseWhere(func(rt *runningTask) bool { return rt.jobID == jobID }) {
+		hosts[rt.worker.Host] = rt.worker
+	}
+	log.Printf("Cancelling job %s: %d queued tasks removed, %d workers notified\n", jobID, removed, len(hosts))
+
+	for _, worker := range hosts {
+		s.cancelOnWorker(worker, jobID, "")
+	}
+}
+
+// JobFinished recibe del JobManager los jobs que terminan (FinishedFn):
+// olvida las duraciones de sus tareas (ver speculation.go) y las
+// exclusiones de workers que tenía (ver failures.go) y, como sus
+// salidas de shuffle ya no las lee nadie, cada worker borra las suyas (POST
+// /shuffle/cleanup).
+func (s *Scheduler) JobFinished(jobID string) {
+	s.mu.Lock()
+	s.forgetJobLocked(jobID)
+	s.forgetJobBlacklistLocked(jobID)
+	s.mu.Unlock()
+
+	b, _ := json.Marshal(map[string]string{"job_id": jobID})
+	for _, w := range s.registry.List() {
+		if w.State == core.WorkerDown {
+			continue
+		}
+		resp, err := s.client.Post(w.Host+"/shuffle/cleanup", "application/json", bytes.NewReader(b))
+		if err != nil {
+			log.Printf("Shuffle cleanup of job %s on %s failed: %v\n", jobID, w.ID, err)
+			continue
+		}
+		resp.Body.Close()
 	}
 }
 
@@ -183,6 +454,26 @@ func (s *Scheduler) EnqueueAssignment(a *core.TaskAssignment) {
 		Attempts:  a.Attempts,
 		Op:        a.Op,
 		Params:    a.Params,
+		Input:     a.Input,
+		Splits:    a.Splits,
+
+		ShuffleWrites: a.ShuffleWrites,
+		ShuffleRead:   a.ShuffleRead,
+		DiscardOutput: a.DiscardOutput,
+		Timeout:       a.Timeout,
+		MemoryMB:      a.MemoryMB,
+		CPUs:          a.CPUs,
+		Preferred:     a.Preferred,
+		Pool:          a.Pool,
+		Priority:      a.Priority,
+		CacheWrite:    a.CacheWrite,
+		CacheRead:     a.CacheRead,
+
+		queuedAt: time.Now(),
+	}
+	if msg, ok := unschedulable(ts, s.registry.List()); ok {
+		s.failUnscheduled(ts, msg)
+		return
 	}
 	s.queue.Push(ts)
 }
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"testing"
+
+	"batchdag/internal/core"
+)
+
+func TestRetryWaitsAsPending(t *testing.T) {
+	s, jm, first := runningJob(t, 2)
+	j, _ := jm.Get("job-1")
+	other := j.Tasks["job-1-s1-p1"]
+	w := &core.WorkerInfo{ID: "w1"}
+
+	fail := func(taskID string) {
+		s.queue.TakeTask(taskID) // el reintento anterior sale de la cola
+		spec := dispatch(t, s, taskID, w)
+		spec.Attempts = j.Tasks[taskID].Attempts
+		rt, _ := s.release(spec.AttemptID)
+		s.handleFailure(rt, "boom", false)
+	}
+
+	// la tarea que espera su reintento no está fallida todavía
+	fail(other.ID)
+	if other.Status != "PENDING" || other.Attempts != 1 || other.Error != "boom" {
+		t.Fatalf("task waiting for a retry = %s after %d attempts (%q), want PENDING after 1", other.Status, other.Attempts, other.Error)
+	}
+
+	// la otra agota sus intentos y, con fail_fast, detiene el job
+	for i := 0; i < s.maxAttempts; i++ {
+		fail(first)
+	}
+	if task := j.Tasks[first]; task.Status != "FAILED" || task.Attempts != s.maxAttempts {
+		t.Errorf("exhausted task = %s after %d attempts, want FAILED after %d", task.Status, task.Attempts, s.maxAttempts)
+	}
+	if j.State != core.JobFailed || other.Status != "CANCELLED" {
+		t.Errorf("job = %s with the retrying task %s, want FAILED with it CANCELLED", j.State, other.Status)
+	}
+}
+
+func TestWorkerDownRequeuesItsAttempts(t *testing.T) {
+	s, jm, first := runningJob(t, 2)
+	w1, w2 := &core.WorkerInfo{ID: "w1"}, &core.WorkerInfo{ID: "w2"}
+	lost := dispatch(t, s, first, w1)
+	dispatch(t, s, "job-1-s1-p1", w2)
+
+	s.onWorkerStateChange(core.WorkerInfo{ID: "w1", State: core.WorkerDown}, core.WorkerUp)
+
+	if s.stillRunning(first) || !s.stillRunning("job-1-s1-p1") {
+		t.Errorf("only the attempt on the DOWN worker should be released")
+	}
+	if got := s.queue.TakeTask(first); got == nil || got == lost {
+		t.Errorf("the lost task was not requeued as a new attempt")
+	}
+	j, _ := jm.Get("job-1")
+	if h := j.Tasks[first].History; len(h) != 1 || h[0].Status != "LOST" || h[0].Worker != "w1" {
+		t.Errorf("history = %+v, want one LOST attempt on w1", h)
+	}
+}
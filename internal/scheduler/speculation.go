@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SpeculationConfig controla la ejecución especulativa: cuando terminó al
+// menos Quantile de las tareas de un stage, las que llevan corriendo más de
+// Multiplier veces la mediana de las terminadas (y más de MinRuntime)
+// reciben una copia en otro worker. Gana el primer intento que termina
+// bien; el otro se corta.
+type SpeculationConfig struct {
+	Enabled    bool
+	Quantile   float64
+	Multiplier float64
+	MinRuntime time.Duration
+}
+
+// DefaultSpeculation deja la especulación apagada con los umbrales
+// habituales.
+var DefaultSpeculation = SpeculationConfig{
+	Quantile:   0.75,
+	Multiplier: 1.5,
+	MinRuntime: time.Second,
+}
+
+func stageKey(jobID, stageID string) string {
+	return jobID + "/" + stageID
+}
+
+// recordDuration guarda cuánto tardó un intento exitoso, para la mediana de
+// su stage.
+func (s *Scheduler) recordDuration(rt *runningTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := stageKey(rt.jobID, rt.spec.StageID)
+	s.durations[key] = append(s.durations[key], time.Since(rt.started))
+}
+
+// cancelOtherAttempts corta los intentos que le quedan a una tarea que ya
+// terminó y saca de la cola las copias que no llegaron a salir.
+func (s *Scheduler) cancelOtherAttempts(jobID, taskID string) {
+	s.mu.Lock()
+	delete(s.speculated, taskID)
+	s.mu.Unlock()
+
+	s.queue.RemoveTasks(map[string]bool{taskID: true})
+	for _, rt := range s.releaseWhere(func(rt *runningTask) bool { return rt.spec.TaskID == taskID }) {
+		log.Printf("Task %s already finished, cancelling its attempt on %s\n", taskID, rt.worker.ID)
+		s.cancelOnWorker(rt.worker, jobID, taskID)
+		s.recordAttempt(rt, "CANCELLED", "another attempt finished first")
+	}
+}
+
+// speculate lanza copias de las tareas que van lentas respecto de las demás
+// de su stage. Lo llama Start periódicamente si la especulación está
+// habilitada.
+func (s *Scheduler) speculate() {
+	cfg := s.Speculation
+	now := time.Now()
+
+	type candidate struct {
+		rt     *runningTask
+		median time.Duration
+	}
+	var cands []candidate
+
+	s.mu.Lock()
+	attempts := map[string]int{}
+	for _, rt := range s.running {
+		attempts[rt.spec.TaskID]++
+	}
+	for _, rt := range s.running {
+		t := rt.spec
+		if attempts[t.TaskID] > 1 || s.speculated[t.TaskID] {
+			continue
+		}
+		durs := s.durations[stageKey(rt.jobID, t.StageID)]
+		if len(durs) == 0 {
+			continue
+		}
+		median := medianDuration(durs)
+		elapsed := now.Sub(rt.started)
+		if elapsed < cfg.MinRuntime || float64(elapsed) < cfg.Multiplier*float64(median) {
+			continue
+		}
+		cands = append(cands, candidate{rt, median})
+	}
+	s.mu.Unlock()
+
+	for _, c := range cands {
+		t := c.rt.spec
+		done, parts, ok := s.jm.StageProgress(t.JobID, t.StageID)
+		if !ok || float64(done) < cfg.Quantile*float64(parts) {
+			continue
+		}
+		if !s.jm.TaskRunnable(t.JobID, t.TaskID) {
+			continue
+		}
+
+		s.mu.Lock()
+		_, running := s.running[t.AttemptID]
+		if !running || s.speculated[t.TaskID] {
+			s.mu.Unlock()
+			continue
+		}
+		s.speculated[t.TaskID] = true
+		s.mu.Unlock()
+
+		spec := t.retry()
+		spec.speculative = true
+		spec.avoid = c.rt.worker.ID
+		spec.queuedAt = now
+		log.Printf("Task %s running for %s on %s (stage median %s), launching a speculative copy\n",
+			t.TaskID, now.Sub(c.rt.started).Round(time.Millisecond), c.rt.worker.ID, c.median.Round(time.Millisecond))
+		s.queue.Push(spec)
+	}
+}
+
+// forgetJobLocked borra lo que se sabe de las tareas de un job que terminó
+// o se canceló.
+func (s *Scheduler) forgetJobLocked(jobID string) {
+	for key := range s.durations {
+		if strings.HasPrefix(key, jobID+"/") {
+			delete(s.durations, key)
+		}
+	}
+	for id := range s.speculated {
+		if strings.HasPrefix(id, jobID+"-") {
+			delete(s.speculated, id)
+		}
+	}
+}
+
+func medianDuration(durs []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"batchdag/internal/core"
+)
+
+func TestJobFinishedForgetsDurations(t *testing.T) {
+	s := NewScheduler(core.NewWorkerRegistry(), core.NewJobManager(), NewTaskQueue())
+	s.durations[stageKey("job-1", "s1")] = []time.Duration{time.Second}
+	s.durations[stageKey("job-2", "s1")] = []time.Duration{time.Second}
+	s.speculated["job-1-s1-p0"] = true
+
+	s.JobFinished("job-1")
+
+	if _, ok := s.durations[stageKey("job-1", "s1")]; ok {
+		t.Errorf("durations of a finished job were kept")
+	}
+	if s.speculated["job-1-s1-p0"] {
+		t.Errorf("speculated tasks of a finished job were kept")
+	}
+	if _, ok := s.durations[stageKey("job-2", "s1")]; !ok {
+		t.Errorf("durations of another job were dropped")
+	}
+}
+
+func TestQueuedCopyReplacesTheRetry(t *testing.T) {
+	cases := []struct {
+		name string
+		lose func(s *Scheduler, rt *runningTask)
+	}{
+		{"attempt fails", func(s *Scheduler, rt *runningTask) { s.handleFailure(rt, "boom", false) }},
+		{"worker down", func(s *Scheduler, rt *runningTask) { s.requeueLost("w1", []*runningTask{rt}, "worker down") }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, jm, taskID := runningJob(t, 1)
+			w := &core.WorkerInfo{ID: "w1"}
+			original := dispatch(t, s, taskID, w)
+
+			// la copia especulativa todavía espera en la cola
+			spec := original.retry()
+			spec.speculative, spec.avoid = true, w.ID
+			s.queue.Push(spec)
+
+			rt, _ := s.release(original.AttemptID)
+			tc.lose(s, rt)
+
+			if n := s.queue.Len(); n != 1 {
+				t.Errorf("%d attempts queued, want only the speculative copy", n)
+			}
+			if j, _ := jm.Get("job-1"); j.Tasks[taskID].Attempts != 0 {
+				t.Errorf("the lost attempt counted although its copy is queued")
+			}
+		})
+	}
+}
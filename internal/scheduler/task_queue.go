@@ -1,7 +1,11 @@
 package scheduler
 
 import (
+	"context"
 	"sync"
+	"time"
+
+	"batchdag/internal/dag"
 )
 
 type TaskSpec struct {
@@ -10,19 +14,70 @@ type TaskSpec struct {
 	StageID   string                 `json:"stage_id"`
 	Partition int                    `json:"partition"`
 	Attempts  int                    `json:"attempts"`
+	AttemptID string                 `json:"attempt_id,omitempty"`
 	Op        string                 `json:"op,omitempty"`
 	Params    map[string]interface{} `json:"params,omitempty"`
+	Input     []interface{}          `json:"input,omitempty"`
+	Splits    []dag.InputSplit       `json:"splits,omitempty"`
+
+	ShuffleWrites []dag.ShuffleWrite `json:"shuffle_writes,omitempty"`
+	ShuffleRead   *dag.ShuffleRead   `json:"shuffle_read,omitempty"`
+	DiscardOutput bool               `json:"discard_output,omitempty"`
+	Timeout       time.Duration      `json:"timeout,omitempty"`
+	MemoryMB      int64              `json:"memory_mb,omitempty"`
+	CPUs          int                `json:"cpus,omitempty"`
+	Preferred     []string           `json:"preferred,omitempty"`
+	Pool          string             `json:"pool,omitempty"`
+	Priority      int                `json:"priority,omitempty"`
+	CacheWrite    *dag.CacheWrite    `json:"cache_write,omitempty"`
+	CacheRead     *dag.CacheRead     `json:"cache_read,omitempty"`
+
+	queuedAt    time.Time // desde cuándo espera lugar en un worker preferido
+	speculative bool      // copia de una tarea que va lenta (ver speculation.go)
+	avoid       string    // worker donde corre el intento original
+	notBefore   time.Time // backoff de un reintento: no sale de la cola antes
+	lastFailed  string    // worker donde falló el último intento
+}
+
+// retry devuelve una copia de t para volver a encolarla como intento normal.
+func (t *TaskSpec) retry() *TaskSpec {
+	r := *t
+	r.speculative = false
+	r.avoid = ""
+	r.notBefore = time.Time{}
+	return &r
 }
 
+// TaskQueue guarda las tareas pendientes en una cola por job. Al sacar
+// tareas no atiende por orden de llegada sino por fair share (ver
+// fairOrderLocked): un job grande no deja sin lugar a los que llegan después.
 type TaskQueue struct {
-	queue []*TaskSpec
+	jobs  map[string]*jobQueue
+	pools map[string]Pool
+	size  int
+	seq   int
 	mu    sync.Mutex
 	cond  *sync.Cond
+	// usageFn (del scheduler) dice cuántas tareas corren ahora por job y
+	// por pool; se llama con la cola bloqueada.
+	usageFn func() Usage
+	// localityWait es la del scheduler (ver readyAt)
+	localityWait time.Duration
+}
+
+// jobQueue son las tareas pendientes de un job, en orden de llegada.
+type jobQueue struct {
+	jobID    string
+	pool     string
+	priority int
+	seq      int // orden de llegada del job, desempata
+	tasks    []*TaskSpec
 }
 
 func NewTaskQueue() *TaskQueue {
 	q := &TaskQueue{
-		queue: []*TaskSpec{},
+		jobs:  make(map[string]*jobQueue),
+		pools: make(map[string]Pool),
 	}
 	q.cond = sync.NewCond(&q.mu)
 	return q
@@ -30,24 +85,202 @@ func NewTaskQueue() *TaskQueue {
 
 func (q *TaskQueue) Push(t *TaskSpec) {
 	q.mu.Lock()
-	q.queue = append(q.queue, t)
-	q.cond.Signal()
+	jq, ok := q.jobs[t.JobID]
+	if !ok {
+		q.seq++
+		jq = &jobQueue{
+			jobID:    t.JobID,
+			pool:     poolName(t.Pool),
+			priority: max(t.Priority, 1),
+			seq:      q.seq,
+		}
+		q.jobs[t.JobID] = jq
+	}
+	jq.tasks = append(jq.tasks, t)
+	q.size++
+	// despertar a todos: cada PopWait acepta tareas distintas (pass)
+	q.cond.Broadcast()
 	q.mu.Unlock()
 }
 
-func (q *TaskQueue) Pop() *TaskSpec {
+// PopWait saca hasta max tareas, esperando a lo sumo wait (o hasta que se
+// cancele ctx) a que haya alguna. Si pass no es nil, cada vez que recorre la
+// cola lo llama una vez para obtener fit y sólo saca las tareas que fit
+// acepta; las demás quedan en la cola. Así lo que fit necesita saber (p.ej.
+// el lugar libre en los workers) se calcula una vez por pasada y no por
+// tarea. Devuelve nil si no llegó ninguna.
+// Mientras espera no recorre la cola: vuelve a mirarla cuando llega una
+// tarea, cuando alguien llama a Wake o cuando vence el backoff o la espera
+// de localidad de alguna que salteó.
+func (q *TaskQueue) PopWait(ctx context.Context, max int, wait time.Duration, pass func() func(t *TaskSpec) bool) []*TaskSpec {
+	deadline := time.Now().Add(wait)
+	timer := time.AfterFunc(wait, q.Wake)
+	defer timer.Stop()
+	stop := context.AfterFunc(ctx, q.Wake)
+	defer stop()
+
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	for len(q.queue) == 0 {
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var fit func(t *TaskSpec) bool
+		if pass != nil && q.size > 0 {
+			fit = pass()
+		}
+		out, retry := q.takeLocked(max, fit)
+		if len(out) > 0 || !time.Now().Before(deadline) {
+			return out
+		}
+		if retry.IsZero() || retry.After(deadline) {
+			retry = deadline
+		}
+		timer.Reset(time.Until(retry))
 		q.cond.Wait()
 	}
-	t := q.queue[0]
-	q.queue = q.queue[1:]
-	return t
+}
+
+// takeLocked saca hasta max tareas que acepte fit (todas si es nil). Cada
+// tarea sale del primer job, en orden de fair share, que tenga alguna que
+// fit acepte; dentro de un job, en orden de llegada. Las que están en
+// backoff (notBefore) se saltean. Un job sin ninguna que fit acepte no se
+// vuelve a mirar en la pasada: lo que sale sólo ocupa más lugar. Devuelve
+// también cuándo puede salir la primera de las salteadas sin que cambie nada
+// más (ver readyAt), o cero. Debe llamarse con q.mu tomado.
+func (q *TaskQueue) takeLocked(max int, fit func(t *TaskSpec) bool) ([]*TaskSpec, time.Time) {
+	if q.size == 0 {
+		return nil, time.Time{}
+	}
+	order := q.fairOrderLocked(q.usageLocked())
+	now := time.Now()
+
+	var out []*TaskSpec
+	var retry time.Time
+	for len(out) < max {
+		jq := order.next()
+		if jq == nil {
+			break
+		}
+		picked := -1
+		for i, t := range jq.tasks {
+			if t.notBefore.After(now) || (fit != nil && !fit(t)) {
+				if at := q.readyAt(t, now); !at.IsZero() && (retry.IsZero() || at.Before(retry)) {
+					retry = at
+				}
+				continue
+			}
+			picked = i
+			break
+		}
+		if picked < 0 {
+			order.drop()
+			continue
+		}
+
+		out = append(out, jq.tasks[picked])
+		if picked == 0 {
+			jq.tasks[0] = nil
+			jq.tasks = jq.tasks[1:]
+		} else {
+			jq.tasks = append(jq.tasks[:picked], jq.tasks[picked+1:]...)
+		}
+		q.size--
+		if len(jq.tasks) == 0 {
+			delete(q.jobs, jq.jobID)
+		}
+		// lo que sale cuenta como en curso para el resto de esta pasada
+		order.took(len(jq.tasks) == 0)
+	}
+	return out, retry
+}
+
+// readyAt devuelve cuándo t, salteada ahora, puede volver a salir de la
+// cola por el solo paso del tiempo: al terminar su backoff o su espera de
+// localidad (ver Scheduler.locality). Cero si no espera ninguna de las dos.
+func (q *TaskQueue) readyAt(t *TaskSpec, now time.Time) time.Time {
+	if t.notBefore.After(now) {
+		return t.notBefore
+	}
+	if len(t.Preferred) > 0 {
+		if at := t.queuedAt.Add(q.localityWait); at.After(now) {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// Wake despierta a los PopWait en espera para que vuelvan a mirar la cola:
+// lo que acepta su fit puede haber cambiado aunque no llegaran tareas.
+func (q *TaskQueue) Wake() {
+	q.mu.Lock()
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// RemoveJob saca de la cola las tareas pendientes de un job y devuelve
+// cuántas sacó.
+func (q *TaskQueue) RemoveJob(jobID string) int {
+	return q.removeIf(func(t *TaskSpec) bool { return t.JobID == jobID })
+}
+
+// RemoveTasks saca de la cola las tareas con esos IDs.
+func (q *TaskQueue) RemoveTasks(ids map[string]bool) int {
+	return q.removeIf(func(t *TaskSpec) bool { return ids[t.TaskID] })
+}
+
+// Queued indica si la tarea taskID del job jobID está en la cola.
+func (q *TaskQueue) Queued(jobID, taskID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if jq, ok := q.jobs[jobID]; ok {
+		for _, t := range jq.tasks {
+			if t.TaskID == taskID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TakeTask saca de la cola la tarea taskID y la devuelve, o nil si no
+// está encolada.
+func (q *TaskQueue) TakeTask(taskID string) *TaskSpec {
+	var out *TaskSpec
+	q.removeIf(func(t *TaskSpec) bool {
+		if t.TaskID != taskID || out != nil {
+			return false
+		}
+		out = t
+		return true
+	})
+	return out
+}
+
+func (q *TaskQueue) removeIf(drop func(t *TaskSpec) bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for id, jq := range q.jobs {
+		kept := jq.tasks[:0]
+		for _, t := range jq.tasks {
+			if !drop(t) {
+				kept = append(kept, t)
+			}
+		}
+		n += len(jq.tasks) - len(kept)
+		clear(jq.tasks[len(kept):])
+		jq.tasks = kept
+		if len(kept) == 0 {
+			delete(q.jobs, id)
+		}
+	}
+	q.size -= n
+	return n
 }
 
 func (q *TaskQueue) Len() int {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	return len(q.queue)
+	return q.size
 }
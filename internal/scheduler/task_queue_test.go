@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPopWaitWakesWhenBackoffEnds(t *testing.T) {
+	q := NewTaskQueue()
+	q.Push(&TaskSpec{JobID: "j", TaskID: "t", notBefore: time.Now().Add(100 * time.Millisecond)})
+
+	start := time.Now()
+	out := q.PopWait(context.Background(), 1, 10*time.Second, nil)
+	if len(out) != 1 {
+		t.Fatalf("PopWait returned %d tasks, want 1", len(out))
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("PopWait took %s to return a task whose backoff ended after 100ms", waited)
+	}
+}
+
+func TestPopWaitWakesWhenLocalityWaitEnds(t *testing.T) {
+	q := NewTaskQueue()
+	q.localityWait = 100 * time.Millisecond
+	q.Push(&TaskSpec{JobID: "j", TaskID: "t", Preferred: []string{"w1"}, queuedAt: time.Now()})
+
+	// como Scheduler.locality: en otro worker sólo después de la espera
+	pass := func() func(t *TaskSpec) bool {
+		return func(t *TaskSpec) bool { return time.Since(t.queuedAt) >= q.localityWait }
+	}
+	start := time.Now()
+	out := q.PopWait(context.Background(), 1, 10*time.Second, pass)
+	if len(out) != 1 {
+		t.Fatalf("PopWait returned %d tasks, want 1", len(out))
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("PopWait took %s to return a task whose locality wait ended after 100ms", waited)
+	}
+}
+
+func TestPopWaitWakesOnPush(t *testing.T) {
+	q := NewTaskQueue()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		q.Push(&TaskSpec{JobID: "j", TaskID: "t"})
+	}()
+	start := time.Now()
+	if out := q.PopWait(context.Background(), 1, 10*time.Second, nil); len(out) != 1 {
+		t.Fatalf("PopWait returned %d tasks, want 1", len(out))
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("PopWait took %s to return a pushed task", waited)
+	}
+}
+
+func TestPopWaitComputesFitOncePerPass(t *testing.T) {
+	q := NewTaskQueue()
+	for i := 0; i < 50; i++ {
+		q.Push(&TaskSpec{JobID: "j", TaskID: fmt.Sprint(i)})
+	}
+
+	passes, calls := 0, 0
+	pass := func() func(t *TaskSpec) bool {
+		passes++
+		return func(t *TaskSpec) bool {
+			calls++
+			return false
+		}
+	}
+	if out := q.PopWait(context.Background(), 1, 50*time.Millisecond, pass); len(out) != 0 {
+		t.Fatalf("PopWait returned %d tasks, want none", len(out))
+	}
+	if passes == 0 || calls < 50 || passes > calls/50 {
+		t.Errorf("%d passes for %d fit calls, want one pass per look at the queue", passes, calls)
+	}
+}
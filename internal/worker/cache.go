@@ -0,0 +1,361 @@
+package worker
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"batchdag/internal/dag"
+	"batchdag/pkg/utils"
+)
+
+// Las tareas de un stage con persist guardan su salida (antes de repartirla
+// en shuffles) según el nivel del stage: en memoria del worker, en disco
+//
+//	<CACHE_DIR>/<clave>/part-<partición>.jsonl
+//
+// o en ambos. CACHE_DIR puede ser un directorio compartido por todos los
+// workers. Las particiones guardadas se sirven por GET /cache/{key}/{partition}
+// a las tareas que las leen desde otro worker.
+//
+// Cada caché tiene un límite en bytes (CACHE_MEMORY_BYTES, 256 MiB, y
+// CACHE_DISK_BYTES, 4 GiB): al pasarlo se desalojan las particiones usadas
+// hace más tiempo. Una partición desalojada que el master todavía cree acá
+// hace fallar la lectura y el master la recalcula por linaje.
+
+var cacheClient = utils.NewHTTPClient(30 * time.Second)
+
+var memCache = struct {
+	sync.Mutex
+	parts map[string][]interface{} // dag.CachePartition -> registros
+	lru   *lruCache
+}{
+	parts: map[string][]interface{}{},
+	lru:   newLRUCache(cacheLimit("CACHE_MEMORY_BYTES", 256<<20)),
+}
+
+// diskCache lleva el uso de los archivos de CACHE_DIR; se arma con lo que
+// ya hay en el directorio la primera vez que se usa.
+var diskCache = struct {
+	sync.Mutex
+	lru    *lruCache
+	loaded bool
+}{lru: newLRUCache(cacheLimit("CACHE_DISK_BYTES", 4<<30))}
+
+// cacheLimit lee un límite en bytes de env; si no está o no es válido
+// devuelve def.
+func cacheLimit(env string, def int64) int64 {
+	if n, err := strconv.ParseInt(os.Getenv(env), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// lruCache lleva el tamaño de cada partición guardada y el orden en que se
+// usaron, para saber cuáles desalojar cuando se pasa de limit bytes.
+type lruCache struct {
+	limit int64
+	size  int64
+	order *list.List               // la más reciente adelante
+	items map[string]*list.Element // dag.CachePartition -> *lruItem
+}
+
+type lruItem struct {
+	name  string
+	bytes int64
+}
+
+func newLRUCache(limit int64) *lruCache {
+	return &lruCache{limit: limit, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// add anota name con su tamaño como la más reciente y devuelve las que hay
+// que desalojar para volver bajo el límite, de la menos usada en adelante.
+// name nunca se desaloja: quien la guarda ya comprobó que entra (ver fits).
+func (c *lruCache) add(name string, bytes int64) []string {
+	c.remove(name)
+	c.items[name] = c.order.PushFront(&lruItem{name: name, bytes: bytes})
+	c.size += bytes
+
+	var evict []string
+	for c.size > c.limit && c.order.Len() > 1 {
+		it := c.order.Back().Value.(*lruItem)
+		c.remove(it.name)
+		evict = append(evict, it.name)
+	}
+	return evict
+}
+
+// fits indica si una partición de bytes puede entrar en la caché.
+func (c *lruCache) fits(bytes int64) bool {
+	return bytes <= c.limit
+}
+
+// touch marca name como usada recién.
+func (c *lruCache) touch(name string) {
+	if e, ok := c.items[name]; ok {
+		c.order.MoveToFront(e)
+	}
+}
+
+func (c *lruCache) remove(name string) {
+	if e, ok := c.items[name]; ok {
+		c.size -= e.Value.(*lruItem).bytes
+		c.order.Remove(e)
+		delete(c.items, name)
+	}
+}
+
+func cacheDir() string {
+	if d := os.Getenv("CACHE_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join(os.TempDir(), "minispark-cache")
+}
+
+func cachePath(key string, p int) string {
+	return filepath.Join(cacheDir(), key, fmt.Sprintf("part-%d.jsonl", p))
+}
+
+// persistOutput guarda la salida de la partición p bajo cw.Key. El archivo
+// se escribe aparte y se renombra al final: nadie lee uno a medio escribir.
+// Una partición más grande que el límite de una caché no se guarda en ella;
+// si no entra en ninguna de las del nivel devuelve un error.
+func persistOutput(cw *dag.CacheWrite, p int, out []interface{}) error {
+	name := dag.CachePartition(cw.Key, p)
+	var size countWriter
+	if err := utils.WriteJSONLines(&size, out); err != nil {
+		return err
+	}
+	stored := false
+
+	if dag.PersistsOnDisk(cw.Level) && diskFits(int64(size)) {
+		final := cachePath(cw.Key, p)
+		if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+			return err
+		}
+		f, err := os.CreateTemp(filepath.Dir(final), filepath.Base(final)+".tmp-")
+		if err != nil {
+			return err
+		}
+		err = utils.WriteJSONLines(f, out)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), final)
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+		diskStored(name, int64(size))
+		stored = true
+	}
+	if dag.PersistsInMemory(cw.Level) && memCache.lru.fits(int64(size)) {
+		memCache.Lock()
+		memCache.parts[name] = out
+		for _, old := range memCache.lru.add(name, int64(size)) {
+			delete(memCache.parts, old)
+		}
+		memCache.Unlock()
+		stored = true
+	}
+	if !stored {
+		return fmt.Errorf("partition %s (%d bytes) is larger than the cache limit", name, size)
+	}
+	return nil
+}
+
+// countWriter cuenta los bytes que se le escriben.
+type countWriter int64
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	*w += countWriter(len(b))
+	return len(b), nil
+}
+
+// loadDiskCacheLocked anota en el LRU los archivos que ya estaban en
+// CACHE_DIR, de los más viejos a los más nuevos.
+func loadDiskCacheLocked() {
+	if diskCache.loaded {
+		return
+	}
+	diskCache.loaded = true
+	files, _ := filepath.Glob(filepath.Join(cacheDir(), "*", "part-*.jsonl"))
+	type found struct {
+		name string
+		info os.FileInfo
+	}
+	var all []found
+	for _, f := range files {
+		num := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "part-"), ".jsonl")
+		p, err := strconv.Atoi(num)
+		info, serr := os.Stat(f)
+		if err != nil || serr != nil {
+			continue
+		}
+		all = append(all, found{dag.CachePartition(filepath.Base(filepath.Dir(f)), p), info})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].info.ModTime().Before(all[j].info.ModTime()) })
+	for _, f := range all {
+		diskCache.lru.add(f.name, f.info.Size())
+	}
+}
+
+// diskFits indica si una partición de bytes puede ir al disco.
+func diskFits(bytes int64) bool {
+	diskCache.Lock()
+	defer diskCache.Unlock()
+	return diskCache.lru.fits(bytes)
+}
+
+// diskStored anota en el LRU un archivo recién guardado y borra los que
+// haya que desalojar.
+func diskStored(name string, bytes int64) {
+	diskCache.Lock()
+	loadDiskCacheLocked()
+	evict := diskCache.lru.add(name, bytes)
+	diskCache.Unlock()
+	for _, old := range evict {
+		if key, p, ok := dag.ParseCachePartition(old); ok {
+			os.Remove(cachePath(key, p))
+		}
+	}
+}
+
+// diskUsed marca como usada recién una partición leída del disco.
+func diskUsed(name string) {
+	diskCache.Lock()
+	loadDiskCacheLocked()
+	diskCache.lru.touch(name)
+	diskCache.Unlock()
+}
+
+// memCached devuelve la partición name si está en memoria y la marca como
+// usada recién.
+func memCached(name string) ([]interface{}, bool) {
+	memCache.Lock()
+	defer memCache.Unlock()
+	recs, ok := memCache.parts[name]
+	if ok {
+		memCache.lru.touch(name)
+	}
+	return recs, ok
+}
+
+// localCached busca la partición p de key en este worker: primero en
+// memoria y después en disco.
+func localCached(key string, p int) ([]interface{}, bool, error) {
+	if recs, ok := memCached(dag.CachePartition(key, p)); ok {
+		return recs, true, nil
+	}
+
+	f, err := os.Open(cachePath(key, p))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	diskUsed(dag.CachePartition(key, p))
+	recs, err := utils.ReadJSONLines(f)
+	return recs, err == nil, err
+}
+
+// loadCached devuelve la partición p guardada bajo cr.Key: la de este
+// worker si la tiene o, si no, la de alguno de cr.Hosts.
+func loadCached(ctx context.Context, cr *dag.CacheRead, p int) ([]interface{}, error) {
+	recs, ok, err := localCached(cr.Key, p)
+	if ok {
+		return recs, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("partition %d of %s is not cached", p, cr.Key)
+	}
+	self := os.Getenv("WORKER_HOST")
+	for _, host := range cr.Hosts {
+		if host == self {
+			continue
+		}
+		body, ferr := utils.GetStream(ctx, cacheClient, fmt.Sprintf("%s/cache/%s/%d", host, cr.Key, p))
+		if ferr != nil {
+			err = ferr
+			continue
+		}
+		recs, ferr = utils.ReadJSONLines(body)
+		body.Close()
+		if ferr != nil {
+			err = ferr
+			continue
+		}
+		return recs, nil
+	}
+	return nil, err
+}
+
+// CachedPartitions devuelve las particiones que guarda el worker (ver
+// dag.CachePartition); las informa al registrarse.
+func CachedPartitions() []string {
+	seen := map[string]bool{}
+	memCache.Lock()
+	for name := range memCache.parts {
+		seen[name] = true
+	}
+	memCache.Unlock()
+
+	files, _ := filepath.Glob(filepath.Join(cacheDir(), "*", "part-*.jsonl"))
+	for _, f := range files {
+		num := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "part-"), ".jsonl")
+		if p, err := strconv.Atoi(num); err == nil {
+			seen[dag.CachePartition(filepath.Base(filepath.Dir(f)), p)] = true
+		}
+	}
+
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CacheHandler sirve una partición guardada por este worker.
+func CacheHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := strconv.Atoi(r.PathValue("partition"))
+	if err != nil {
+		http.Error(w, "invalid partition", http.StatusBadRequest)
+		return
+	}
+	key := filepath.Base(r.PathValue("key"))
+
+	if recs, ok := memCached(dag.CachePartition(key, p)); ok {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		utils.WriteJSONLines(w, recs)
+		return
+	}
+
+	f, err := os.Open(cachePath(key, p))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	diskUsed(dag.CachePartition(key, p))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	io.Copy(w, f)
+}
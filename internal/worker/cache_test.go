@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"batchdag/internal/dag"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache(100)
+	c.add("a", 40)
+	c.add("b", 40)
+	c.touch("a")
+
+	if got := c.add("c", 40); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("evicted %v, want [b]", got)
+	}
+	if c.size != 80 {
+		t.Errorf("size = %d, want 80", c.size)
+	}
+	if c.fits(101) {
+		t.Errorf("a partition larger than the limit fits")
+	}
+}
+
+func TestPersistOutputEvictsFromDisk(t *testing.T) {
+	t.Setenv("CACHE_DIR", t.TempDir())
+	defer func(lru *lruCache) { diskCache.lru, diskCache.loaded = lru, false }(diskCache.lru)
+	diskCache.lru, diskCache.loaded = newLRUCache(30), false
+
+	recs := []interface{}{"0123456789"} // 13 bytes en JSON Lines
+	cw := &dag.CacheWrite{Key: "k", Level: dag.PersistDisk}
+	for p := 0; p < 3; p++ {
+		if err := persistOutput(cw, p, recs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(cachePath("k", 0)); !os.IsNotExist(err) {
+		t.Errorf("the oldest partition was not evicted from disk")
+	}
+	for _, p := range []int{1, 2} {
+		if _, ok, err := localCached("k", p); !ok || err != nil {
+			t.Errorf("partition %d is not cached: %v", p, err)
+		}
+	}
+
+	big := []interface{}{string(make([]byte, 64))}
+	if err := persistOutput(cw, 3, big); err == nil {
+		t.Errorf("a partition larger than the disk limit was persisted")
+	}
+}
@@ -1,11 +1,16 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"time"
+
+	"batchdag/internal/dag"
 )
 
 type TaskRequest struct {
@@ -13,10 +18,42 @@ type TaskRequest struct {
 	TaskID    string                 `json:"task_id"`
 	StageID   string                 `json:"stage_id"`
 	Partition int                    `json:"partition"`
+	Attempt   int                    `json:"attempt"`
+	AttemptID string                 `json:"attempt_id"`
 	Op        string                 `json:"op,omitempty"`
 	Params    map[string]interface{} `json:"params,omitempty"`
+	Input     []interface{}          `json:"input,omitempty"`
+	Splits    []dag.InputSplit       `json:"splits,omitempty"`
+
+	ShuffleWrites []dag.ShuffleWrite `json:"shuffle_writes,omitempty"`
+	ShuffleRead   *dag.ShuffleRead   `json:"shuffle_read,omitempty"`
+	DiscardOutput bool               `json:"discard_output,omitempty"`
+	CacheWrite    *dag.CacheWrite    `json:"cache_write,omitempty"`
+	CacheRead     *dag.CacheRead     `json:"cache_read,omitempty"`
+}
+
+// taskReport es lo que el worker informa al master al terminar un intento
+// (ver core.TaskReport).
+type taskReport struct {
+	WorkerID  string                   `json:"worker_id"`
+	JobID     string                   `json:"job_id"`
+	TaskID    string                   `json:"task_id"`
+	Attempt   int                      `json:"attempt"`
+	AttemptID string                   `json:"attempt_id"`
+	Status    string                   `json:"status"`
+	Error     string                   `json:"error,omitempty"`
+	Output    []interface{}            `json:"output,omitempty"`
+	Samples   map[string][]interface{} `json:"samples,omitempty"`
+	Persisted bool                     `json:"persisted,omitempty"`
+	Infra     bool                     `json:"infra,omitempty"`
+	CacheMiss bool                     `json:"cache_miss,omitempty"`
 }
 
+var reportClient = newMasterClient(30 * time.Second)
+
+// TaskHandler acepta la tarea (202) y la corre en segundo plano; al
+// terminar informa el resultado al master (POST /tasks/report). Así una
+// tarea puede tardar lo que necesite sin depender del timeout HTTP.
 func TaskHandler(w http.ResponseWriter, r *http.Request) {
 	var req TaskRequest
 	body, _ := io.ReadAll(r.Body)
@@ -26,25 +63,149 @@ func TaskHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Worker %s executing task %s (op=%s stage=%s partition=%d)\n",
 		workerID, req.TaskID, req.Op, req.StageID, req.Partition)
 
-	switch req.Op {
+	op, ok := LookupOp(req.Op)
+	if !ok {
+		http.Error(w, "unknown op: "+req.Op, http.StatusBadRequest)
+		return
+	}
+
+	runAsync(op, &req)
 
-	case "read_csv":
-		out, err := OpReadCSV(req.Params, req.Partition)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
+}
+
+// runAsync corre la tarea en segundo plano y reporta el resultado.
+func runAsync(op OpFunc, req *TaskRequest) {
+	workerID := os.Getenv("WORKER_ID")
+
+	// el master puede cancelar la tarea (POST /task/cancel) mientras corre
+	ctx, done := startTask(context.Background(), req)
+	go func() {
+		defer done()
+		rep := runTask(ctx, op, req)
+		rep.WorkerID = workerID
+		if rep.Status == "cancelled" {
+			log.Printf("Worker %s: task %s cancelled\n", workerID, req.TaskID)
+		}
+		reportTask(rep)
+	}()
+}
+
+// runTask ejecuta la tarea: trae su shuffle si es ancha, corre el operador
+// y reparte la salida en los shuffles de sus hijos anchos.
+func runTask(ctx context.Context, op OpFunc, req *TaskRequest) *taskReport {
+	rep := &taskReport{
+		JobID:     req.JobID,
+		TaskID:    req.TaskID,
+		Attempt:   req.Attempt,
+		AttemptID: req.AttemptID,
+		Status:    "error",
+	}
+
+	var out []interface{}
+	if req.CacheRead != nil {
+		// stage leído de la caché: su salida ya está guardada, no hace
+		// falta input ni correr el op
+		cached, err := loadCached(ctx, req.CacheRead, req.Partition)
 		if err != nil {
-			http.Error(w, "read_csv error: "+err.Error(), http.StatusInternalServerError)
-			return
+			rep.Error = "cache read error: " + err.Error()
+			rep.Infra = true
+			rep.CacheMiss = true
+			return cancelled(ctx, rep)
+		}
+		out = cached
+	} else {
+		// stage ancho: el input es el bucket de esta partición en cada worker
+		if req.ShuffleRead != nil {
+			in, err := fetchShuffle(ctx, req.ShuffleRead)
+			if err != nil {
+				rep.Error = "shuffle fetch error: " + err.Error()
+				rep.Infra = true
+				return cancelled(ctx, rep)
+			}
+			req.Input = in
 		}
 
-		resp := map[string]interface{}{
-			"status": "ok",
-			"output": out,
+		res, err := op(ctx, req)
+		if err == nil {
+			err = ctx.Err()
 		}
-		json.NewEncoder(w).Encode(resp)
-		return
+		if err != nil {
+			rep.Error = req.Op + " error: " + err.Error()
+			return cancelled(ctx, rep)
+		}
+		out = res
+	}
+
+	// stage con persist: guardar la salida para otros jobs; si no se puede,
+	// la tarea igual termina bien
+	if req.CacheWrite != nil {
+		if err := persistOutput(req.CacheWrite, req.Partition, out); err != nil {
+			log.Printf("Task %s: cannot persist output: %v\n", req.TaskID, err)
+		} else {
+			rep.Persisted = true
+		}
+	}
+
+	// hijos anchos: dejar la salida repartida en buckets locales
+	samples := map[string][]interface{}{}
+	for _, sw := range req.ShuffleWrites {
+		sample, err := writeShuffle(sw, req.TaskID, out)
+		if err != nil {
+			rep.Error = "shuffle write error: " + err.Error()
+			rep.Infra = true
+			return rep
+		}
+		if sample != nil {
+			samples[sw.ShuffleID] = sample
+		}
+	}
 
-	// otros operadores vendrán aquí
+	rep.Status = "ok"
+	if !req.DiscardOutput {
+		rep.Output = out
+	}
+	if len(samples) > 0 {
+		rep.Samples = samples
+	}
+	return rep
+}
+
+// cancelled marca el reporte como cancelado si el error vino de un cancel
+// del master.
+func cancelled(ctx context.Context, rep *taskReport) *taskReport {
+	if ctx.Err() == context.Canceled {
+		rep.Status = "cancelled"
+		rep.Error = "task cancelled"
+	}
+	return rep
+}
+
+// reportRetryMax es la espera más larga entre dos envíos de un reporte.
+const reportRetryMax = 5 * time.Second
 
-	default:
-		w.Write([]byte(`{"status":"ok"}`))
+// reportTask envía el reporte al master hasta que un líder lo atiende. Si
+// el master está caído o cambia de líder (un standby tarda hasta el TTL del
+// lease en tomar el lugar), el reporte espera sin perderse: mientras tanto
+// el intento sigue en curso en este worker (ver RunningAttempts), así que
+// si el master reinicia lo adopta al registrarse el worker y después acepta
+// el reporte. Un 4xx (p.ej. 409, intento ya reemplazado) no se reintenta.
+func reportTask(rep *taskReport) {
+	delay := 500 * time.Millisecond
+	for {
+		status, err := postMaster(context.Background(), reportClient, "/tasks/report", rep, nil)
+		if err == nil && status < 500 {
+			if status >= 400 {
+				log.Printf("Master rejected report for task %s: status %d\n", rep.TaskID, status)
+			}
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("status %d", status)
+		}
+		log.Printf("Could not report task %s to master, retrying in %s: %v\n", rep.TaskID, delay, err)
+		time.Sleep(delay)
+		delay = min(delay*2, reportRetryMax)
 	}
 }
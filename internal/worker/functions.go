@@ -0,0 +1,205 @@
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Funciones con nombre que los operadores map, flat_map, filter y
+// reduce_by_key aplican sobre cada registro. El DAG las referencia con
+// params.fn; el campo sobre el que actúan se elige con params.field.
+
+type MapFn func(rec map[string]interface{}, params map[string]interface{}) (map[string]interface{}, error)
+type FlatMapFn func(rec map[string]interface{}, params map[string]interface{}) ([]map[string]interface{}, error)
+type FilterFn func(rec map[string]interface{}, params map[string]interface{}) (bool, error)
+type ReduceFn func(acc, v float64) float64
+
+var mapFns = map[string]MapFn{
+	"identity": func(rec map[string]interface{}, _ map[string]interface{}) (map[string]interface{}, error) {
+		return rec, nil
+	},
+	"to_lower": stringMapFn(strings.ToLower),
+	"to_upper": stringMapFn(strings.ToUpper),
+	"trim":     stringMapFn(strings.TrimSpace),
+}
+
+var flatMapFns = map[string]FlatMapFn{
+	"tokenize": fnTokenize,
+	"split":    fnSplit,
+}
+
+var filterFns = map[string]FilterFn{
+	"non_empty": fnNonEmpty,
+	"equals":    fnEquals,
+	"contains":  fnContains,
+}
+
+var reduceFns = map[string]ReduceFn{
+	"sum":   func(acc, v float64) float64 { return acc + v },
+	"count": func(acc, _ float64) float64 { return acc + 1 },
+	"min": func(acc, v float64) float64 {
+		if v < acc {
+			return v
+		}
+		return acc
+	},
+	"max": func(acc, v float64) float64 {
+		if v > acc {
+			return v
+		}
+		return acc
+	},
+}
+
+// reduceInit es el acumulador inicial de un grupo a partir de su primer valor.
+func reduceInit(name string, v float64) float64 {
+	if name == "count" {
+		return 1
+	}
+	return v
+}
+
+// stringMapFn aplica f al campo params.field o, si no se indica, a todos los
+// campos string del registro.
+func stringMapFn(f func(string) string) MapFn {
+	return func(rec map[string]interface{}, params map[string]interface{}) (map[string]interface{}, error) {
+		out := make(map[string]interface{}, len(rec))
+		field := paramString(params, "field", "")
+		for k, v := range rec {
+			if s, ok := v.(string); ok && (field == "" || field == k) {
+				out[k] = f(s)
+				continue
+			}
+			out[k] = v
+		}
+		return out, nil
+	}
+}
+
+// fnTokenize parte params.field (por defecto "line") en palabras y emite un
+// registro {"token": palabra} por cada una.
+func fnTokenize(rec map[string]interface{}, params map[string]interface{}) ([]map[string]interface{}, error) {
+	field := paramString(params, "field", "line")
+	as := paramString(params, "as", "token")
+
+	words := strings.FieldsFunc(fieldString(rec, field), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := make([]map[string]interface{}, 0, len(words))
+	for _, w := range words {
+		out = append(out, map[string]interface{}{as: w})
+	}
+	return out, nil
+}
+
+// fnSplit parte params.field por params.sep (por defecto ",").
+func fnSplit(rec map[string]interface{}, params map[string]interface{}) ([]map[string]interface{}, error) {
+	field := paramString(params, "field", "line")
+	sep := paramString(params, "sep", ",")
+	as := paramString(params, "as", field)
+
+	var out []map[string]interface{}
+	for _, p := range strings.Split(fieldString(rec, field), sep) {
+		out = append(out, map[string]interface{}{as: p})
+	}
+	return out, nil
+}
+
+func fnNonEmpty(rec map[string]interface{}, params map[string]interface{}) (bool, error) {
+	field := paramString(params, "field", "")
+	if field == "" {
+		for _, v := range rec {
+			if s, ok := v.(string); !ok || strings.TrimSpace(s) != "" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return strings.TrimSpace(fieldString(rec, field)) != "", nil
+}
+
+func fnEquals(rec map[string]interface{}, params map[string]interface{}) (bool, error) {
+	field := paramString(params, "field", "")
+	if field == "" {
+		return false, fmt.Errorf("equals requires params.field")
+	}
+	want, ok := params["value"]
+	if !ok {
+		return false, fmt.Errorf("equals requires params.value")
+	}
+	return fmt.Sprint(rec[field]) == fmt.Sprint(want), nil
+}
+
+func fnContains(rec map[string]interface{}, params map[string]interface{}) (bool, error) {
+	field := paramString(params, "field", "line")
+	sub := paramString(params, "value", "")
+	return strings.Contains(fieldString(rec, field), sub), nil
+}
+
+// fieldString devuelve el campo como string (vacío si no existe).
+func fieldString(rec map[string]interface{}, field string) string {
+	v, ok := rec[field]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	case nil:
+		return 0, fmt.Errorf("missing value")
+	default:
+		return 0, fmt.Errorf("not a number: %v", v)
+	}
+}
+
+func paramString(params map[string]interface{}, key, def string) string {
+	if v, ok := params[key].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func paramBool(params map[string]interface{}, key string) bool {
+	switch v := params[key].(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+func paramInt(params map[string]interface{}, key string, def int) int {
+	switch v := params[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// En modo pull (WORKER_MODE=pull) el worker no espera que el master le
+// mande tareas: las pide con POST /api/v1/tasks/lease según sus slots
+// libres, las corre igual que en push y reporta por /tasks/report. Los
+// leases se renuevan con el heartbeat (ver RunningTaskIDs).
+
+// leaseWait es cuánto espera el master una tarea antes de devolver una
+// respuesta vacía; el cliente espera un poco más.
+const leaseWait = 20 * time.Second
+
+var leaseClient = newMasterClient(leaseWait + 10*time.Second)
+
+type leaseResponse struct {
+	Leases []struct {
+		Task      json.RawMessage `json:"task"`
+		ExpiresAt time.Time       `json:"expires_at"`
+	} `json:"leases"`
+}
+
+// PullLoop pide tareas al master mientras haya slots libres. No vuelve.
+func PullLoop(workerID string) {
+	slots := Slots()
+	for {
+		free := slots - runningCount()
+		if free <= 0 {
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+
+		var resp leaseResponse
+		if err := leaseTasks(workerID, free, &resp); err != nil {
+			log.Printf("Worker %s: lease failed: %v\n", workerID, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, l := range resp.Leases {
+			var req TaskRequest
+			if err := json.Unmarshal(l.Task, &req); err != nil {
+				log.Printf("Worker %s: invalid lease: %v\n", workerID, err)
+				continue
+			}
+			log.Printf("Worker %s executing leased task %s (op=%s stage=%s partition=%d)\n",
+				workerID, req.TaskID, req.Op, req.StageID, req.Partition)
+
+			op, ok := LookupOp(req.Op)
+			if !ok {
+				reportTask(&taskReport{
+					WorkerID:  workerID,
+					JobID:     req.JobID,
+					TaskID:    req.TaskID,
+					Attempt:   req.Attempt,
+					AttemptID: req.AttemptID,
+					Status:    "error",
+					Error:     "unknown op: " + req.Op,
+				})
+				continue
+			}
+			runAsync(op, &req)
+		}
+	}
+}
+
+func leaseTasks(workerID string, slots int, out *leaseResponse) error {
+	body := map[string]interface{}{
+		"worker_id": workerID,
+		"slots":     slots,
+		"wait_ms":   leaseWait.Milliseconds(),
+	}
+	status, err := postMaster(context.Background(), leaseClient, "/api/v1/tasks/lease", body, out)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("status %d", status)
+	}
+	return nil
+}
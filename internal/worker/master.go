@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"batchdag/pkg/utils"
+)
+
+// MASTER_URL puede listar varios masters separados por comas (uno líder y
+// los demás en standby). El worker habla con uno a la vez: si no responde
+// pasa al siguiente, y si el que responde está en standby le redirige (307)
+// al líder, que pasa a ser el master actual.
+var masters struct {
+	mu   sync.Mutex
+	urls []string
+	cur  int
+}
+
+func init() {
+	for _, u := range strings.Split(os.Getenv("MASTER_URL"), ",") {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			masters.urls = append(masters.urls, u)
+		}
+	}
+}
+
+// MasterURL devuelve el master con el que habla el worker ahora.
+func MasterURL() string {
+	masters.mu.Lock()
+	defer masters.mu.Unlock()
+	if len(masters.urls) == 0 {
+		return ""
+	}
+	return masters.urls[masters.cur]
+}
+
+// masterDown pasa al siguiente master de la lista si url, que no
+// respondió, sigue siendo el actual.
+func masterDown(url string) {
+	masters.mu.Lock()
+	defer masters.mu.Unlock()
+	if len(masters.urls) < 2 || masters.urls[masters.cur] != url {
+		return
+	}
+	masters.cur = (masters.cur + 1) % len(masters.urls)
+	log.Printf("Master %s unreachable, trying %s\n", url, masters.urls[masters.cur])
+}
+
+// followLeader toma como master actual al que apunta un redirect.
+func followLeader(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("too many redirects")
+	}
+	leader := req.URL.Scheme + "://" + req.URL.Host
+
+	masters.mu.Lock()
+	defer masters.mu.Unlock()
+	if len(masters.urls) > 0 && masters.urls[masters.cur] == leader {
+		return nil
+	}
+	i := 0
+	for i < len(masters.urls) && masters.urls[i] != leader {
+		i++
+	}
+	if i == len(masters.urls) {
+		masters.urls = append(masters.urls, leader)
+	}
+	masters.cur = i
+	log.Printf("Following master leader at %s\n", leader)
+	return nil
+}
+
+// newMasterClient es un cliente HTTP para hablar con el master que sigue
+// los redirects al líder.
+func newMasterClient(timeout time.Duration) *http.Client {
+	c := utils.NewHTTPClient(timeout)
+	c.CheckRedirect = followLeader
+	return c
+}
+
+var masterClient = newMasterClient(10 * time.Second)
+
+// postMaster hace POST de in a path en el master actual y, si no responde,
+// pasa al siguiente para el próximo intento.
+func postMaster(ctx context.Context, client *http.Client, path string, in, out interface{}) (int, error) {
+	master := MasterURL()
+	if master == "" {
+		return 0, fmt.Errorf("MASTER_URL not set")
+	}
+	status, err := utils.PostJSON(ctx, client, master+path, in, out)
+	if err != nil {
+		masterDown(master)
+	}
+	return status, err
+}
+
+// SendToMaster manda data al master (registro y heartbeats) y devuelve el
+// status de la respuesta.
+func SendToMaster(path string, data interface{}) (int, error) {
+	return postMaster(context.Background(), masterClient, path, data, nil)
+}
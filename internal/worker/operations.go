@@ -1,73 +1,335 @@
 package worker
 
 import (
-	"bytes"
-	"encoding/csv"
-	"io"
-	"io/ioutil"
-	"path/filepath"
-	"strconv"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
+
+	"batchdag/internal/dag"
 )
 
-func OpReadCSV(params map[string]interface{}, partition int) ([]interface{}, error) {
-	pathI, ok := params["path"]
+// OpFunc ejecuta un operador sobre una tarea y devuelve los registros de salida.
+type OpFunc func(ctx context.Context, req *TaskRequest) ([]interface{}, error)
+
+// operators es el registro de operadores que el worker sabe ejecutar,
+// indexado por el campo op del stage.
+var operators = map[string]OpFunc{
+	"read_csv":      OpReadCSV,
+	"read_jsonl":    OpReadJSONL,
+	"read_text":     OpReadText,
+	"map":           OpMap,
+	"flat_map":      OpFlatMap,
+	"filter":        OpFilter,
+	"reduce_by_key": OpReduceByKey,
+	"group_by_key":  OpGroupByKey,
+	"sort_by_key":   OpSortByKey,
+	"write_csv":     OpSink,
+	"write_jsonl":   OpSink,
+	"write_text":    OpSink,
+}
+
+// RegisterOp agrega (o reemplaza) un operador en el registro.
+func RegisterOp(name string, fn OpFunc) {
+	operators[name] = fn
+}
+
+// LookupOp devuelve el operador registrado con ese nombre.
+func LookupOp(name string) (OpFunc, bool) {
+	fn, ok := operators[name]
+	return fn, ok
+}
+
+// OpMap aplica params.fn a cada registro del input.
+func OpMap(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	name := paramString(req.Params, "fn", "")
+	fn, ok := mapFns[name]
 	if !ok {
-		return nil, nil
-	}
-	path := pathI.(string)
-
-	parts := 1
-	if p, ok := params["partitions"]; ok {
-		switch v := p.(type) {
-		case float64:
-			parts = int(v)
-		case int:
-			parts = v
-		case string:
-			if pi, err := strconv.Atoi(v); err == nil {
-				parts = pi
-			}
+		return nil, fmt.Errorf("unknown map fn: %q", name)
+	}
+
+	out := make([]interface{}, 0, len(req.Input))
+	for i, in := range req.Input {
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		rec, err := fn(asRecord(in), req.Params)
+		if err != nil {
+			return nil, err
 		}
+		out = append(out, rec)
 	}
+	return out, nil
+}
 
-	files, err := filepath.Glob(path)
-	if err != nil {
-		return nil, err
+// OpFlatMap aplica params.fn a cada registro y concatena los resultados.
+func OpFlatMap(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	name := paramString(req.Params, "fn", "")
+	fn, ok := flatMapFns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown flat_map fn: %q", name)
 	}
 
-	var all []string
-	for _, f := range files {
-		data, err := ioutil.ReadFile(f)
+	out := []interface{}{}
+	for i, in := range req.Input {
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		recs, err := fn(asRecord(in), req.Params)
 		if err != nil {
 			return nil, err
 		}
+		for _, r := range recs {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
 
-		r := csv.NewReader(bytes.NewReader(data))
-		for {
-			rec, err := r.Read()
-			if err == io.EOF {
-				break
-			}
+// OpFilter conserva los registros para los que params.fn devuelve true.
+func OpFilter(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	name := paramString(req.Params, "fn", "")
+	fn, ok := filterFns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter fn: %q", name)
+	}
+
+	out := []interface{}{}
+	for i, in := range req.Input {
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		rec := asRecord(in)
+		keep, err := fn(rec, req.Params)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// OpReduceByKey agrupa el input (ya traído del shuffle) por params.key y
+// combina params.value de cada grupo con params.fn (sum, count, min, max).
+// Si el registro no tiene params.value cuenta como 1. El resultado queda en
+// el campo params.as (por defecto "value").
+func OpReduceByKey(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	key := paramString(req.Params, "key", "")
+	if key == "" {
+		return nil, fmt.Errorf("reduce_by_key requires params.key")
+	}
+	name := paramString(req.Params, "fn", "sum")
+	fn, ok := reduceFns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown reduce fn: %q", name)
+	}
+	valueField := paramString(req.Params, "value", "")
+	as := paramString(req.Params, "as", "value")
+
+	acc := map[string]float64{}
+	keys := map[string]interface{}{}
+	for i, in := range req.Input {
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		rec := asRecord(in)
+		k := fmt.Sprint(rec[key])
+
+		v := 1.0
+		if valueField != "" {
+			f, err := toFloat(rec[valueField])
 			if err != nil {
-				lines := strings.Split(string(data), "\n")
-				for _, l := range lines {
-					if strings.TrimSpace(l) != "" {
-						all = append(all, l)
-					}
-				}
-				break
+				return nil, fmt.Errorf("reduce_by_key value %q: %w", valueField, err)
 			}
-			all = append(all, strings.Join(rec, ","))
+			v = f
+		}
+
+		if prev, ok := acc[k]; ok {
+			acc[k] = fn(prev, v)
+		} else {
+			acc[k] = reduceInit(name, v)
+			keys[k] = rec[key]
 		}
 	}
 
+	out := make([]interface{}, 0, len(acc))
+	for _, k := range sortedKeys(acc) {
+		out = append(out, map[string]interface{}{key: keys[k], as: acc[k]})
+	}
+	return out, nil
+}
+
+// OpGroupByKey agrupa el input por params.key y emite un registro por clave
+// con todos sus registros en el campo params.as (por defecto "values").
+func OpGroupByKey(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	key := paramString(req.Params, "key", "")
+	if key == "" {
+		return nil, fmt.Errorf("group_by_key requires params.key")
+	}
+	as := paramString(req.Params, "as", "values")
+
+	groups := map[string][]interface{}{}
+	keys := map[string]interface{}{}
+	for i, in := range req.Input {
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		rec := asRecord(in)
+		k := fmt.Sprint(rec[key])
+		if _, ok := groups[k]; !ok {
+			keys[k] = rec[key]
+		}
+		groups[k] = append(groups[k], rec)
+	}
+
+	out := make([]interface{}, 0, len(groups))
+	for _, k := range sortedKeys(groups) {
+		out = append(out, map[string]interface{}{key: keys[k], as: groups[k]})
+	}
+	return out, nil
+}
+
+// OpSortByKey ordena el input por params.key. Con el partitioner range por
+// defecto de sort_by_key, concatenar las particiones en orden da la salida
+// ordenada completa.
+func OpSortByKey(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	key := paramString(req.Params, "key", "")
+	if key == "" {
+		return nil, fmt.Errorf("sort_by_key requires params.key")
+	}
+
+	out := make([]interface{}, 0, len(req.Input))
+	for _, in := range req.Input {
+		out = append(out, asRecord(in))
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return dag.CompareKeys(out[i].(map[string]interface{})[key], out[j].(map[string]interface{})[key]) < 0
+	})
+	return out, nil
+}
+
+// asRecord normaliza un elemento del input a un registro con campos.
+// Los valores sueltos se envuelven como {"line": valor}.
+func asRecord(v interface{}) map[string]interface{} {
+	switch r := v.(type) {
+	case map[string]interface{}:
+		return r
+	case string:
+		return map[string]interface{}{"line": r}
+	default:
+		return map[string]interface{}{"line": fmt.Sprint(r)}
+	}
+}
+
+// OpReadCSV lee las líneas de los splits de la tarea. Sin header ni schema
+// emite cada registro CSV como {"line": "campo1,campo2,..."}; con ellos
+// emite un mapa columna -> valor tipado (ver schema.go).
+func OpReadCSV(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	opts, err := parseCSVOptions(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	cols := newColumnCache(opts)
+
 	out := []interface{}{}
-	for i, line := range all {
-		if (i % parts) == partition {
+	err = forEachInputLine(ctx, req, func(l inputLine) error {
+		if strings.TrimSpace(l.Text) == "" {
+			return nil
+		}
+		// la primera línea de cada archivo es el header
+		if opts.header && l.Offset == 0 {
+			return cols.setHeader(l.Path, l.Text)
+		}
+
+		fields, err := opts.split(l.Text)
+		if errors.Is(err, errMultilineField) {
+			return fmt.Errorf("%s@%d: %w", l.Path, l.Offset, err)
+		}
+		if !opts.typed() {
+			line := l.Text
+			if err == nil {
+				line = strings.Join(fields, ",")
+			}
 			out = append(out, map[string]interface{}{"line": line})
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s@%d: %w", l.Path, l.Offset, err)
+		}
+
+		fileCols, err := cols.get(l.Path)
+		if err != nil {
+			return err
+		}
+		rec, err := opts.record(fileCols, fields)
+		if err != nil {
+			return fmt.Errorf("%s@%d: %w", l.Path, l.Offset, err)
 		}
+		out = append(out, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return out, nil
+}
 
+// OpReadJSONL lee un objeto JSON por línea; cada objeto es un registro.
+// Los valores que no son objetos se emiten como {"value": v}.
+func OpReadJSONL(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	out := []interface{}{}
+	err := forEachInputLine(ctx, req, func(l inputLine) error {
+		if strings.TrimSpace(l.Text) == "" {
+			return nil
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(l.Text), &v); err != nil {
+			return fmt.Errorf("%s@%d: invalid json: %w", l.Path, l.Offset, err)
+		}
+		rec, ok := v.(map[string]interface{})
+		if !ok {
+			rec = map[string]interface{}{"value": v}
+		}
+		if l.LineNo > 0 {
+			rec["line_no"] = l.LineNo
+		}
+		out = append(out, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return out, nil
 }
+
+// OpReadText emite un registro {"line": "..."} por línea, incluidas las
+// vacías. Con params.line_numbers agrega "line_no" (desde 1, dentro de su
+// archivo).
+func OpReadText(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	out := []interface{}{}
+	err := forEachInputLine(ctx, req, func(l inputLine) error {
+		rec := map[string]interface{}{"line": l.Text}
+		if l.LineNo > 0 {
+			rec["line_no"] = l.LineNo
+		}
+		out = append(out, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Slots es cuántas tareas corre el worker a la vez (WORKER_SLOTS, por
+// defecto la cantidad de CPUs). El master no le manda más que eso.
+func Slots() int {
+	if n, err := strconv.Atoi(os.Getenv("WORKER_SLOTS")); err == nil && n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+// CPUs es la cantidad de CPUs que ve el proceso.
+func CPUs() int {
+	return runtime.NumCPU()
+}
+
+// MemoryMB es la memoria que el worker ofrece a las tareas
+// (WORKER_MEMORY_MB, por defecto la total de la máquina según
+// /proc/meminfo). Devuelve 0 si no la sabe: el master entonces no le pone
+// límite de memoria, en vez de no mandarle las tareas que piden memoria.
+func MemoryMB() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("WORKER_MEMORY_MB"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// MemTotal:       16314236 kB
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			return kb / 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,256 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"batchdag/internal/dag"
+)
+
+// Opciones de read_csv:
+//
+//	"header": true            la primera línea de cada archivo trae los nombres de columna
+//	"delimiter": ";"          separador de campos (por defecto ",")
+//	"quote": false            desactiva el manejo de comillas (split literal)
+//	"lazy_quotes": true       acepta comillas sueltas dentro de los campos
+//	"schema": [{"name": "edad", "type": "int"}, ...]
+//
+// Con header o schema cada registro sale como un mapa columna -> valor
+// tipado; sin ninguno de los dos se mantiene {"line": "..."}. Los tipos
+// soportados son string, int, float, bool y time (params "format" de la
+// columna, por defecto RFC3339). Un campo vacío de tipo no string queda nil.
+//
+// Cada línea es un registro: los splits se cortan por líneas, así que un
+// campo entre comillas no puede contener saltos de línea (quedaría partido
+// entre dos registros, o entre dos particiones). Una línea que abre comillas
+// y no las cierra hace fallar la tarea en vez de leerse mal.
+
+// Column es una columna declarada en params.schema.
+type Column struct {
+	Name   string
+	Type   string
+	Format string
+}
+
+type csvOptions struct {
+	header      bool
+	compression string // params.compression, para leer el header aparte
+	delimiter   rune
+	quote       bool
+	lazyQuotes  bool
+	schema      []Column
+}
+
+func parseCSVOptions(params map[string]interface{}) (*csvOptions, error) {
+	opts := &csvOptions{delimiter: ',', quote: true}
+
+	opts.header, _ = params["header"].(bool)
+	opts.compression = paramString(params, "compression", "")
+	opts.lazyQuotes, _ = params["lazy_quotes"].(bool)
+	if q, ok := params["quote"].(bool); ok {
+		opts.quote = q
+	}
+
+	if d := paramString(params, "delimiter", ""); d != "" {
+		if d == `\t` {
+			d = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(d)
+		if size != len(d) || r == '"' || r == '\r' || r == '\n' {
+			return nil, fmt.Errorf("invalid delimiter %q", d)
+		}
+		opts.delimiter = r
+	}
+
+	if raw, ok := params["schema"]; ok {
+		cols, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("schema must be a list of columns")
+		}
+		for i, c := range cols {
+			m, ok := c.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("schema column %d must be an object", i)
+			}
+			col := Column{
+				Name:   paramString(m, "name", ""),
+				Type:   paramString(m, "type", "string"),
+				Format: paramString(m, "format", time.RFC3339),
+			}
+			if col.Name == "" {
+				return nil, fmt.Errorf("schema column %d has no name", i)
+			}
+			switch col.Type {
+			case "string", "int", "float", "bool", "time":
+			default:
+				return nil, fmt.Errorf("schema column %s: unknown type %q", col.Name, col.Type)
+			}
+			opts.schema = append(opts.schema, col)
+		}
+	}
+	return opts, nil
+}
+
+// typed indica si los registros salen como mapas columna -> valor.
+func (o *csvOptions) typed() bool {
+	return o.header || len(o.schema) > 0
+}
+
+// errMultilineField es el error de una línea con un campo entre comillas
+// que sigue en la línea siguiente.
+var errMultilineField = errors.New("quoted field spans several lines, which read_csv does not support (records are split by line)")
+
+// split parte una línea en campos según delimiter y quote.
+func (o *csvOptions) split(line string) ([]string, error) {
+	if !o.quote {
+		return strings.Split(line, string(o.delimiter)), nil
+	}
+	// las comillas escapadas ("") van de a pares: si quedan impares, hay un
+	// campo que no cierra en esta línea
+	if !o.lazyQuotes && strings.Count(line, `"`)%2 == 1 {
+		return nil, errMultilineField
+	}
+	r := csv.NewReader(strings.NewReader(line))
+	r.Comma = o.delimiter
+	r.LazyQuotes = o.lazyQuotes
+	r.FieldsPerRecord = -1
+	return r.Read()
+}
+
+// columns devuelve las columnas de un archivo: con header, sus nombres con
+// el tipo que declare el schema para cada uno (string si no lo declara);
+// sin header, el schema tal cual.
+func (o *csvOptions) columns(header []string) []Column {
+	if len(header) == 0 {
+		return o.schema
+	}
+	byName := map[string]Column{}
+	for _, c := range o.schema {
+		byName[c.Name] = c
+	}
+	cols := make([]Column, len(header))
+	for i, name := range header {
+		if c, ok := byName[name]; ok {
+			cols[i] = c
+		} else {
+			cols[i] = Column{Name: name, Type: "string"}
+		}
+	}
+	return cols
+}
+
+// record arma el registro tipado de una fila. Las columnas de más se
+// nombran col<N>; las que faltan quedan nil.
+func (o *csvOptions) record(cols []Column, fields []string) (map[string]interface{}, error) {
+	rec := make(map[string]interface{}, len(cols))
+	for i, c := range cols {
+		if i >= len(fields) {
+			rec[c.Name] = nil
+			continue
+		}
+		v, err := convertField(fields[i], c)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		rec[c.Name] = v
+	}
+	for i := len(cols); i < len(fields); i++ {
+		rec[fmt.Sprintf("col%d", i)] = fields[i]
+	}
+	return rec, nil
+}
+
+func convertField(s string, c Column) (interface{}, error) {
+	if c.Type == "string" {
+		return s, nil
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	switch c.Type {
+	case "int":
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	case "time":
+		return time.Parse(c.Format, s)
+	}
+	return s, nil
+}
+
+// columnCache guarda las columnas ya resueltas de cada archivo. Con header,
+// el split que empieza al principio del archivo lo toma de su primera línea
+// y los demás (y, sin splits, las particiones a las que no les tocó esa
+// línea) lo leen aparte.
+type columnCache struct {
+	opts *csvOptions
+	cols map[string][]Column
+}
+
+func newColumnCache(opts *csvOptions) *columnCache {
+	return &columnCache{opts: opts, cols: map[string][]Column{}}
+}
+
+func (c *columnCache) get(path string) ([]Column, error) {
+	if !c.opts.header {
+		return c.opts.schema, nil
+	}
+	if cols, ok := c.cols[path]; ok {
+		return cols, nil
+	}
+	line, err := c.readHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.setHeader(path, line); err != nil {
+		return nil, err
+	}
+	return c.cols[path], nil
+}
+
+// setHeader resuelve las columnas de un archivo a partir de su header.
+func (c *columnCache) setHeader(path, line string) error {
+	hdr, err := c.opts.split(strings.TrimPrefix(line, "\ufeff"))
+	if err != nil {
+		return fmt.Errorf("%s: invalid header: %w", path, err)
+	}
+	for i := range hdr {
+		hdr[i] = strings.TrimSpace(hdr[i])
+	}
+	c.cols[path] = c.opts.columns(hdr)
+	return nil
+}
+
+// readHeader lee la primera línea del archivo, descomprimida si hace falta.
+func (c *columnCache) readHeader(path string) (string, error) {
+	codec, err := dag.Compression(path, c.opts.compression)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r, err := decompress(f, path, codec)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("%s: missing header", path)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
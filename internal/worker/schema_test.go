@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitRejectsMultilineQuotedField(t *testing.T) {
+	opts, err := parseCSVOptions(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opts.split(`1,"first line`); !errors.Is(err, errMultilineField) {
+		t.Fatalf("unterminated quote: err = %v, want errMultilineField", err)
+	}
+	fields, err := opts.split(`1,"say ""hi""",x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 3 || fields[1] != `say "hi"` {
+		t.Fatalf("fields = %q", fields)
+	}
+
+	// con lazy_quotes las comillas sueltas son literales
+	opts.lazyQuotes = true
+	if _, err := opts.split(`1,a"b`); err != nil {
+		t.Fatalf("lazy quotes: %v", err)
+	}
+}
+
+// sin splits, cada partición tiene que tipar sus registros con el header,
+// también si el archivo está comprimido y no le tocó la primera línea.
+func TestReadCSVHeaderLegacyGzip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "people.csv.gz")
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("name,age\nana,30\nbeto,41\ncarla,25\n"))
+	zw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	params := map[string]interface{}{
+		"path":       path,
+		"partitions": float64(2),
+		"header":     true,
+		"schema":     []interface{}{map[string]interface{}{"name": "age", "type": "int"}},
+	}
+	var recs []interface{}
+	for p := 0; p < 2; p++ {
+		out, err := OpReadCSV(context.Background(), &TaskRequest{Op: "read_csv", Partition: p, Params: params})
+		if err != nil {
+			t.Fatalf("partition %d: %v", p, err)
+		}
+		recs = append(recs, out...)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("got %d records, want 3: %v", len(recs), recs)
+	}
+	for _, r := range recs {
+		rec := r.(map[string]interface{})
+		if _, ok := rec["age"].(int64); !ok || rec["name"] == nil {
+			t.Errorf("record not typed by header: %v", rec)
+		}
+	}
+}
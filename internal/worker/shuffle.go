@@ -0,0 +1,260 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"batchdag/internal/dag"
+	"batchdag/pkg/utils"
+)
+
+// Los buckets de shuffle se guardan en disco local del worker, un archivo
+// NDJSON por bucket:
+//
+//	<SHUFFLE_DIR>/<shuffle_id>/<map_task_id>/bucket-<n>.jsonl
+//
+// y se sirven por GET /shuffle/{shuffle}/{task}/{bucket} a las tareas
+// reduce-side de cualquier worker. Si el particionador necesita muestra
+// (range sin boundaries) se guarda un único block.jsonl y el bucket se arma
+// al servirlo, con el partitioner que manda la tarea reduce-side.
+
+var shuffleClient = utils.NewHTTPClient(30 * time.Second)
+
+func shuffleDir() string {
+	if d := os.Getenv("SHUFFLE_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join(os.TempDir(), "minispark-shuffle")
+}
+
+const blockFile = "block.jsonl"
+
+func bucketPath(shuffleID, taskID string, bucket int) string {
+	return filepath.Join(shuffleDir(), shuffleID, taskID, fmt.Sprintf("bucket-%d.jsonl", bucket))
+}
+
+// writeShuffle reparte la salida de una tarea map-side en sw.Buckets
+// archivos según sw.Partitioner. Los buckets se escriben en un directorio
+// temporal y se renombran al final, así un reintento de la misma tarea
+// reemplaza por completo la salida anterior. Si el particionador necesita
+// muestra, guarda un único bloque y devuelve una muestra de sus claves.
+func writeShuffle(sw dag.ShuffleWrite, taskID string, out []interface{}) ([]interface{}, error) {
+	files := map[string][]interface{}{}
+	var sample []interface{}
+
+	if sw.Partitioner.NeedsSample() {
+		files[blockFile] = out
+		sample = sampleKeys(out, sw.Partitioner.Key, sw.Partitioner.SampleLimit())
+	} else {
+		p, err := dag.NewPartitioner(sw.Partitioner, sw.Buckets)
+		if err != nil {
+			return nil, err
+		}
+		buckets := make([][]interface{}, sw.Buckets)
+		for _, rec := range out {
+			b := p.Partition(asRecord(rec))
+			if b < 0 || b >= len(buckets) {
+				return nil, fmt.Errorf("partitioner %s returned partition %d of %d", sw.Partitioner.Type, b, len(buckets))
+			}
+			buckets[b] = append(buckets[b], rec)
+		}
+		for b, recs := range buckets {
+			files[fmt.Sprintf("bucket-%d.jsonl", b)] = recs
+		}
+	}
+
+	final := filepath.Join(shuffleDir(), sw.ShuffleID, taskID)
+	if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(final), taskID+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	for name, recs := range files {
+		f, err := os.Create(filepath.Join(tmp, name))
+		if err != nil {
+			return nil, err
+		}
+		err = utils.WriteJSONLines(f, recs)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.RemoveAll(final); err != nil {
+		return nil, err
+	}
+	return sample, os.Rename(tmp, final)
+}
+
+// ShuffleOutputs devuelve los IDs de las tareas map-side cuya salida de
+// shuffle está en disco; el worker los informa al registrarse para que el
+// master sepa qué salidas sigue teniendo.
+func ShuffleOutputs() []string {
+	dirs, _ := filepath.Glob(filepath.Join(shuffleDir(), "*", "*"))
+	seen := map[string]bool{}
+	out := []string{}
+	for _, d := range dirs {
+		id := filepath.Base(d)
+		if strings.Contains(id, ".tmp-") || seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	return out
+}
+
+// ShuffleCleanupHandler atiende POST /shuffle/cleanup del master:
+// {"job_id": ...} borra las salidas de shuffle del job, que ya terminó.
+func ShuffleCleanupHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JobID == "" {
+		http.Error(w, "invalid cleanup request", http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "ok",
+		"removed": removeJobShuffles(req.JobID),
+	})
+}
+
+// removeJobShuffles borra los shuffles del job (su ID empieza con el del
+// job, ver core.shuffleID) y devuelve cuántos borró.
+func removeJobShuffles(jobID string) int {
+	entries, _ := os.ReadDir(shuffleDir())
+	n := 0
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), jobID+"-") {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(shuffleDir(), e.Name())); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// sampleKeys toma hasta limit claves del output (reservoir sampling).
+func sampleKeys(out []interface{}, key string, limit int) []interface{} {
+	sample := make([]interface{}, 0, limit)
+	for i, rec := range out {
+		k := asRecord(rec)[key]
+		if len(sample) < limit {
+			sample = append(sample, k)
+		} else if j := rand.Intn(i + 1); j < limit {
+			sample[j] = k
+		}
+	}
+	return sample
+}
+
+// fetchShuffle trae el bucket rd.Bucket de cada tarea map-side listada en
+// rd.Sources y concatena los registros.
+func fetchShuffle(ctx context.Context, rd *dag.ShuffleRead) ([]interface{}, error) {
+	out := []interface{}{}
+	for _, src := range rd.Sources {
+		url := fmt.Sprintf("%s/shuffle/%s/%s/%d", src.Host, rd.ShuffleID, src.TaskID, rd.Bucket)
+		if rd.Partitioner != nil {
+			spec, _ := json.Marshal(rd.Partitioner)
+			url += fmt.Sprintf("?n=%d&partitioner=%s", rd.Buckets, neturl.QueryEscape(string(spec)))
+		}
+
+		var recs []interface{}
+		err := utils.Retry(3, 200*time.Millisecond, func() error {
+			body, err := utils.GetStream(ctx, shuffleClient, url)
+			if err != nil {
+				return err
+			}
+			defer body.Close()
+			recs, err = utils.ReadJSONLines(body)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("fetch %s bucket %d from %s: %w", src.TaskID, rd.Bucket, src.Host, err)
+		}
+		out = append(out, recs...)
+	}
+	return out, nil
+}
+
+// ShuffleHandler sirve un bucket guardado por este worker. Con el query
+// partitioner (y n) arma el bucket filtrando el bloque sin particionar.
+func ShuffleHandler(w http.ResponseWriter, r *http.Request) {
+	var bucket int
+	if _, err := fmt.Sscanf(r.PathValue("bucket"), "%d", &bucket); err != nil {
+		http.Error(w, "invalid bucket", http.StatusBadRequest)
+		return
+	}
+	shuffleID := filepath.Base(r.PathValue("shuffle"))
+	taskID := filepath.Base(r.PathValue("task"))
+
+	if q := r.URL.Query().Get("partitioner"); q != "" {
+		serveFromBlock(w, r, shuffleID, taskID, bucket, q)
+		return
+	}
+
+	f, err := os.Open(bucketPath(shuffleID, taskID, bucket))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	io.Copy(w, f)
+}
+
+func serveFromBlock(w http.ResponseWriter, r *http.Request, shuffleID, taskID string, bucket int, specJSON string) {
+	var spec dag.PartitionerSpec
+	if err := json.Unmarshal([]byte(specJSON), &spec); err != nil {
+		http.Error(w, "invalid partitioner", http.StatusBadRequest)
+		return
+	}
+	n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+	p, err := dag.NewPartitioner(&spec, n)
+	if err != nil {
+		http.Error(w, "invalid partitioner: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(shuffleDir(), shuffleID, taskID, blockFile))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	recs, err := utils.ReadJSONLines(f)
+	if err != nil {
+		http.Error(w, "corrupt shuffle block: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var out []interface{}
+	for _, rec := range recs {
+		if p.Partition(asRecord(rec)) == bucket {
+			out = append(out, rec)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	utils.WriteJSONLines(w, out)
+}
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"batchdag/internal/dag"
+)
+
+func TestShuffleRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		spec *dag.PartitionerSpec
+	}{
+		{"hash", &dag.PartitionerSpec{Type: dag.PartitionHash, Key: "k"}},
+		{"range", &dag.PartitionerSpec{Type: dag.PartitionRange, Key: "k", Boundaries: []interface{}{"h", "p"}}},
+		{"sampled range", &dag.PartitionerSpec{Type: dag.PartitionRange, Key: "k"}},
+	}
+	const buckets = 3
+	maps := map[string][]interface{}{
+		"map-p0": {rec("a"), rec("q"), rec("h"), rec("z")},
+		"map-p1": {rec("b"), rec("i"), rec("p"), rec("y"), rec("a")},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /shuffle/{shuffle}/{task}/{bucket}", ShuffleHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SHUFFLE_DIR", t.TempDir())
+			sw := dag.ShuffleWrite{ShuffleID: "job-1-reduce", Buckets: buckets, Partitioner: tc.spec}
+
+			// map-side: cada tarea escribe sus buckets (o su bloque y la muestra)
+			var sample []interface{}
+			rd := &dag.ShuffleRead{ShuffleID: sw.ShuffleID, Buckets: buckets}
+			for task, out := range maps {
+				s, err := writeShuffle(sw, task, out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sample = append(sample, s...)
+				rd.Sources = append(rd.Sources, dag.ShuffleSource{Host: srv.URL, TaskID: task})
+			}
+			spec := tc.spec
+			if spec.NeedsSample() {
+				if len(sample) == 0 {
+					t.Fatalf("a sampled partitioner returned no key sample")
+				}
+				resolved := *spec
+				resolved.Boundaries = dag.RangeBoundaries(sample, buckets)
+				spec, rd.Partitioner = &resolved, &resolved
+			}
+			p, err := dag.NewPartitioner(spec, buckets)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// reduce-side: cada bucket trae sólo sus claves, de todas las tareas
+			total, used := 0, 0
+			for b := 0; b < buckets; b++ {
+				rd.Bucket = b
+				recs, err := fetchShuffle(context.Background(), rd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range recs {
+					if got := p.Partition(asRecord(r)); got != b {
+						t.Errorf("bucket %d got %v, which belongs to bucket %d", b, r, got)
+					}
+				}
+				total += len(recs)
+				if len(recs) > 0 {
+					used++
+				}
+			}
+			if total != 9 || used < 2 {
+				t.Errorf("read %d records from %d buckets, want 9 spread over several", total, used)
+			}
+		})
+	}
+}
+
+func TestFetchShuffleMissingOutput(t *testing.T) {
+	t.Setenv("SHUFFLE_DIR", t.TempDir())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /shuffle/{shuffle}/{task}/{bucket}", ShuffleHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	rd := &dag.ShuffleRead{ShuffleID: "job-1-reduce", Buckets: 2, Sources: []dag.ShuffleSource{{Host: srv.URL, TaskID: "map-p0"}}}
+	if _, err := fetchShuffle(context.Background(), rd); err == nil {
+		t.Errorf("fetching a bucket no worker wrote succeeded")
+	}
+}
+
+func rec(k string) map[string]interface{} {
+	return map[string]interface{}{"k": k, "v": fmt.Sprintf("val-%s", k)}
+}
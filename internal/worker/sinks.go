@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"batchdag/internal/dag"
+)
+
+// Los operadores sink escriben su input en params.path (un directorio). Cada
+// intento de tarea escribe un part file en
+//
+//	<path>/_temporary/<job_id>/<task_id>-<attempt_id>/part-<partición>.<ext>
+//
+// (cada intento en su directorio, aunque corran dos a la vez) y devuelve
+// un registro {"file": ..., "records": n}. El master mueve los part files
+// al directorio final recién cuando todo el stage terminó, así un
+// reintento o una tarea a medias nunca dejan salida duplicada o parcial.
+
+type sinkWriter func(w io.Writer, recs []interface{}, params map[string]interface{}) error
+
+var sinkWriters = map[string]sinkWriter{
+	"write_csv":   writeCSV,
+	"write_jsonl": writeJSONL,
+	"write_text":  writeText,
+}
+
+// OpSink escribe el input de la tarea en su part file temporal.
+func OpSink(ctx context.Context, req *TaskRequest) ([]interface{}, error) {
+	write, ok := sinkWriters[req.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink: %s", req.Op)
+	}
+	dir := paramString(req.Params, "path", "")
+	if dir == "" {
+		return nil, fmt.Errorf("%s requires params.path", req.Op)
+	}
+
+	tmpDir := filepath.Join(dir, dag.SinkTempDir, req.JobID, req.TaskID+"-"+req.AttemptID)
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return nil, err
+	}
+	file := filepath.Join(tmpDir, dag.PartFileName(req.Op, req.Partition))
+
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(f)
+	err = write(bw, req.Input, req.Params)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+
+	return []interface{}{
+		map[string]interface{}{"file": file, "records": len(req.Input)},
+	}, nil
+}
+
+// writeCSV escribe params.columns (o las claves del primer registro, en
+// orden) con header salvo "header": false.
+func writeCSV(w io.Writer, recs []interface{}, params map[string]interface{}) error {
+	var cols []string
+	if raw, ok := params["columns"].([]interface{}); ok {
+		for _, c := range raw {
+			cols = append(cols, fmt.Sprint(c))
+		}
+	} else if len(recs) > 0 {
+		cols = sortedKeys(asRecord(recs[0]))
+	}
+
+	cw := csv.NewWriter(w)
+	if d := paramString(params, "delimiter", ""); d != "" {
+		if d == `\t` {
+			d = "\t"
+		}
+		cw.Comma = []rune(d)[0]
+	}
+	if h, ok := params["header"].(bool); !ok || h {
+		if err := cw.Write(cols); err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(cols))
+	for _, in := range recs {
+		rec := asRecord(in)
+		for i, c := range cols {
+			row[i] = ""
+			if v, ok := rec[c]; ok && v != nil {
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSONL(w io.Writer, recs []interface{}, _ map[string]interface{}) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeText escribe params.field (por defecto "line") de cada registro; los
+// registros sin ese campo se escriben como JSON.
+func writeText(w io.Writer, recs []interface{}, params map[string]interface{}) error {
+	field := paramString(params, "field", "line")
+	for _, in := range recs {
+		rec := asRecord(in)
+		var line string
+		if v, ok := rec[field]; ok {
+			line = fmt.Sprint(v)
+		} else {
+			b, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			line = string(b)
+		}
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"batchdag/internal/dag"
+)
+
+// Los operadores fuente (read_csv, read_jsonl, read_text) leen líneas de los
+// input splits que les planificó el master. Si la tarea no trae splits (el
+// master no ve los archivos) el worker lee todos los archivos de params.path
+// y se queda con las líneas i % params.partitions == partición.
+//
+// Los archivos .gz / .bz2 (o según params.compression) se descomprimen con
+// la biblioteca estándar; siempre llegan enteros en un solo split.
+//
+// Con params.line_numbers cada línea lleva su número dentro del archivo;
+// para un split que no empieza al principio eso implica contar los saltos de
+// línea anteriores, así que sólo se calcula si se pide.
+
+// inputLine es una línea de entrada junto con el archivo y el offset en el
+// que empieza (Offset 0 es la primera línea del archivo). LineNo (desde 1)
+// sólo se llena con params.line_numbers.
+type inputLine struct {
+	Path   string
+	Offset int64
+	LineNo int64
+	Text   string
+}
+
+// forEachInputLine llama fn por cada línea de entrada de la tarea.
+func forEachInputLine(ctx context.Context, req *TaskRequest, fn func(l inputLine) error) error {
+	numbered := paramBool(req.Params, "line_numbers")
+	if len(req.Splits) == 0 {
+		return forEachLegacyLine(ctx, req, numbered, fn)
+	}
+	for _, sp := range req.Splits {
+		if err := readSplit(ctx, sp, numbered, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSplit lee las líneas cuyo primer byte cae dentro del split.
+func readSplit(ctx context.Context, sp dag.InputSplit, numbered bool, fn func(l inputLine) error) error {
+	f, err := os.Open(sp.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if sp.Compression != dag.CompressionNone {
+		return readCompressed(ctx, f, sp, numbered, fn)
+	}
+
+	start, end := sp.Offset, sp.Offset+sp.Length
+	pos := start
+	if start > 0 {
+		// retroceder un byte: si justo antes hay un '\n' el split empieza en
+		// una línea completa; si no, la línea parcial es del split anterior
+		if _, err := f.Seek(start-1, io.SeekStart); err != nil {
+			return err
+		}
+		pos = start - 1
+	}
+	br := bufio.NewReader(f)
+	if start > 0 {
+		skipped, err := br.ReadString('\n')
+		pos += int64(len(skipped))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var lineNo int64
+	if numbered {
+		if lineNo, err = countLines(f, pos); err != nil {
+			return err
+		}
+	}
+
+	return readLines(ctx, br, sp.Path, pos, end, numbered, lineNo, fn)
+}
+
+// readCompressed lee el archivo comprimido completo. Offset y LineNo de
+// cada línea son relativos al contenido descomprimido.
+func readCompressed(ctx context.Context, f io.Reader, sp dag.InputSplit, numbered bool, fn func(l inputLine) error) error {
+	r, err := decompress(f, sp.Path, sp.Compression)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return readLines(ctx, bufio.NewReader(r), sp.Path, 0, math.MaxInt64, numbered, 0, fn)
+}
+
+// decompress devuelve el contenido de f según compression (ver
+// dag.Compression); sin compresión, f tal cual.
+func decompress(f io.Reader, path, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case dag.CompressionNone:
+		return io.NopCloser(f), nil
+	case dag.CompressionGzip:
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return zr, nil
+	case dag.CompressionBzip2:
+		return io.NopCloser(bzip2.NewReader(f)), nil
+	}
+	return nil, fmt.Errorf("%s: unknown compression %q", path, compression)
+}
+
+// readLines entrega las líneas que empiezan antes de end. pos es el offset
+// de la próxima línea y lineNo el número de la anterior.
+func readLines(ctx context.Context, br *bufio.Reader, path string, pos, end int64, numbered bool, lineNo int64, fn func(l inputLine) error) error {
+	for n := 0; pos < end; n++ {
+		if n%1024 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			l := inputLine{Path: path, Offset: pos, Text: strings.TrimRight(line, "\r\n")}
+			if numbered {
+				lineNo++
+				l.LineNo = lineNo
+			}
+			pos += int64(len(line))
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// countLines cuenta los saltos de línea en [0, upto) del archivo.
+func countLines(f io.ReaderAt, upto int64) (int64, error) {
+	var n int64
+	buf := make([]byte, 64*1024)
+	r := io.NewSectionReader(f, 0, upto)
+	for {
+		k, err := r.Read(buf)
+		n += int64(bytes.Count(buf[:k], []byte{'\n'}))
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// forEachLegacyLine lee todos los archivos de params.path y entrega las
+// líneas que le tocan a la partición por módulo. Las líneas vacías cuentan
+// y se entregan igual que con splits: cada operador decide si las saltea.
+func forEachLegacyLine(ctx context.Context, req *TaskRequest, numbered bool, fn func(l inputLine) error) error {
+	path := paramString(req.Params, "path", "")
+	if path == "" {
+		return nil
+	}
+	parts := paramInt(req.Params, "partitions", 1)
+	if parts <= 0 {
+		parts = 1
+	}
+
+	files, err := filepath.Glob(path)
+	if err != nil {
+		return err
+	}
+
+	i := 0
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		codec, err := dag.Compression(f, paramString(req.Params, "compression", ""))
+		if err != nil {
+			return err
+		}
+		sp := dag.InputSplit{Path: f, Offset: 0, Length: fi.Size(), Compression: codec}
+		err = readSplit(ctx, sp, numbered, func(l inputLine) error {
+			defer func() { i++ }()
+			if i%parts != req.Partition {
+				return nil
+			}
+			return fn(l)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
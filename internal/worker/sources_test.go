@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"batchdag/internal/dag"
+)
+
+// read_text tiene que dar los mismos registros con splits que sin ellos
+// (master que no ve los archivos), incluidas las líneas vacías.
+func TestReadTextSameRecordsWithAndWithoutSplits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(path, []byte("a\n\nb\n   \nc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const parts = 2
+	params := map[string]interface{}{"path": path, "partitions": float64(parts)}
+	splits, err := dag.PlanSplits(path, parts, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(withSplits bool) int {
+		n := 0
+		for p := 0; p < parts; p++ {
+			req := &TaskRequest{Op: "read_text", Partition: p, Params: params}
+			if withSplits {
+				req.Splits = splits[p]
+			}
+			out, err := OpReadText(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n += len(out)
+		}
+		return n
+	}
+	if split, legacy := count(true), count(false); split != 5 || legacy != 5 {
+		t.Fatalf("records: %d with splits, %d without, want 5", split, legacy)
+	}
+}
@@ -1 +1,121 @@
-package worker
\ No newline at end of file
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// runningTask es una tarea en ejecución en este worker; cancel corta el
+// contexto que reciben su fetch de shuffle y su operador.
+type runningTask struct {
+	jobID   string
+	taskID  string
+	attempt int
+	cancel  context.CancelFunc
+}
+
+var (
+	runningMu sync.Mutex
+	running   = map[string]*runningTask{} // por ID de intento
+)
+
+// startTask registra la tarea y devuelve su contexto y la función que la
+// saca del registro al terminar.
+func startTask(parent context.Context, req *TaskRequest) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	rt := &runningTask{jobID: req.JobID, taskID: req.TaskID, attempt: req.Attempt, cancel: cancel}
+
+	runningMu.Lock()
+	running[req.AttemptID] = rt
+	runningMu.Unlock()
+
+	return ctx, func() {
+		runningMu.Lock()
+		if running[req.AttemptID] == rt {
+			delete(running, req.AttemptID)
+		}
+		runningMu.Unlock()
+		cancel()
+	}
+}
+
+// cancelTasks cancela taskID o, si viene vacío, todas las tareas de jobID.
+// Devuelve cuántas canceló.
+func cancelTasks(jobID, taskID string) int {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	n := 0
+	for _, rt := range running {
+		if taskID != "" && rt.taskID != taskID {
+			continue
+		}
+		if taskID == "" && rt.jobID != jobID {
+			continue
+		}
+		rt.cancel()
+		n++
+	}
+	return n
+}
+
+// runningCount devuelve cuántas tareas están corriendo.
+func runningCount() int {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	return len(running)
+}
+
+// RunningTaskIDs devuelve las tareas en curso; en modo pull van en el
+// heartbeat para renovar sus leases.
+func RunningTaskIDs() []string {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	ids := make([]string, 0, len(running))
+	for _, rt := range running {
+		ids = append(ids, rt.taskID)
+	}
+	return ids
+}
+
+// RunningAttempt es un intento en curso (o que todavía no pudo reportar)
+// tal como lo informa el worker al registrarse.
+type RunningAttempt struct {
+	JobID     string `json:"job_id"`
+	TaskID    string `json:"task_id"`
+	Attempt   int    `json:"attempt"`
+	AttemptID string `json:"attempt_id"`
+}
+
+// RunningAttempts devuelve los intentos en curso para que el master, si
+// reinició, los adopte en vez de volver a correrlos.
+func RunningAttempts() []RunningAttempt {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	out := make([]RunningAttempt, 0, len(running))
+	for id, rt := range running {
+		out = append(out, RunningAttempt{JobID: rt.jobID, TaskID: rt.taskID, Attempt: rt.attempt, AttemptID: id})
+	}
+	return out
+}
+
+type cancelRequest struct {
+	JobID  string `json:"job_id"`
+	TaskID string `json:"task_id,omitempty"`
+}
+
+// CancelHandler atiende POST /task/cancel del master: {"job_id": ...} cancela
+// todas las tareas del job que estén corriendo acá; con task_id sólo esa.
+func CancelHandler(w http.ResponseWriter, r *http.Request) {
+	var req cancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.JobID == "" && req.TaskID == "") {
+		http.Error(w, "invalid cancel request", http.StatusBadRequest)
+		return
+	}
+	n := cancelTasks(req.JobID, req.TaskID)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "ok",
+		"cancelled": n,
+	})
+}
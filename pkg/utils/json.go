@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// ReadJSONLines decodifica un valor JSON por línea (NDJSON).
+func ReadJSONLines(r io.Reader) ([]interface{}, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	out := []interface{}{}
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+}
+
+// WriteJSONLines escribe cada registro como una línea JSON (NDJSON).
+func WriteJSONLines(w io.Writer, recs []interface{}) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, r := range recs {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,19 @@
+package utils
+
+import "time"
+
+// Retry ejecuta fn hasta attempts veces, duplicando la espera entre intentos.
+// Devuelve el último error si ningún intento tuvo éxito.
+func Retry(attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i < attempts-1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}